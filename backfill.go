@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// runBackfill imports the history of one account over an explicit date range. Only
+// transactions missing from the ledger are created in Sure: already imported ones are
+// left untouched and the account's last sync date is not changed, so the regular
+// incremental sync carries on as before.
+func runBackfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	accountFlag := fs.String("account", "", "SimpleFIN account ID or mapped account name (required)")
+	fromFlag := fs.String("from", "", "First day to import, YYYY-MM-DD (required)")
+	toFlag := fs.String("to", "", "Last day to import, YYYY-MM-DD (default today)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sure-simplefin-sync backfill --account <id|name> --from YYYY-MM-DD [--to YYYY-MM-DD]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *accountFlag == "" || *fromFlag == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	from, err := time.Parse("2006-01-02", *fromFlag)
+	if err != nil {
+		log.Fatalf("Invalid --from date %q: %v", *fromFlag, err)
+	}
+	to := time.Now()
+	if *toFlag != "" {
+		day, err := time.Parse("2006-01-02", *toFlag)
+		if err != nil {
+			log.Fatalf("Invalid --to date %q: %v", *toFlag, err)
+		}
+		to = day.AddDate(0, 0, 1) // Include the whole last day
+	}
+	if !to.After(from) {
+		log.Fatalf("--to must not be before --from")
+	}
+
+	config := LoadConfig()
+	ConfigureHTTPClients(config)
+	if config.AccessURL == "" {
+		log.Fatal("No AccessURL in config.json, run a sync first to claim the setup token")
+	}
+
+	accountID, accConfig, ok := findMappedAccount(config, *accountFlag)
+	if !ok {
+		log.Fatalf("Account %q is not mapped in config.json", *accountFlag)
+	}
+	if accConfig.BalanceOnly {
+		log.Fatalf("Account %s is balance_only, it has no transactions to backfill", accConfig.Name)
+	}
+
+	rules := loadRules(config)
+	sureAccounts, err := FetchSureAccounts(config.SureBaseURL, config.SureAPIKey)
+	if err != nil {
+		log.Printf("Failed to fetch Sure accounts: %v", err)
+	}
+	if err := resolveAmountSigns(config, sureAccounts); err != nil {
+		log.Fatalf("Cannot determine amount signs: %v", err)
+	}
+	accConfig = config.AccountMap[accountID]
+
+	ledger, err := OpenLedger(ledgerFile)
+	if err != nil {
+		log.Fatalf("Failed to open transaction ledger: %v", err)
+	}
+	defer ledger.Close()
+	RecoverIntents(config, ledger)
+
+	start, end := from.Unix(), to.Unix()
+	quota := LoadRequestQuota(config.DailyRequestBudget())
+	needed := pageCount(start, end)
+	if needed > quota.Remaining() {
+		log.Fatalf("Backfill needs %d SimpleFIN requests but only %d remain in today's budget, narrow the range or try tomorrow", needed, quota.Remaining())
+	}
+	quota.Reserve(needed)
+
+	log.Printf("Backfilling %s from %s to %s...", accConfig.Name, from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"))
+	fetcher := &simplefinFetcher{accessURL: config.AccessURL, quota: quota, workers: config.FetchWorkerCount()}
+	account := SFAccount{ID: accountID} // Name, org and currency are filled in from the fetched pages for rules and templates
+	fetchErr := fetcher.fetchAccountTransactions(log.Default(), &account, start, end, false)
+	if err := quota.Save(); err != nil {
+		log.Printf("Warning: Failed to save SimpleFIN request count: %v", err)
+	}
+	if fetchErr != nil {
+		log.Fatalf("Failed to fetch transactions for %s: %v", accConfig.Name, fetchErr)
+	}
+	log.Printf("Pulled %d transactions", len(account.Transactions))
+
+	ops, deferred := planAccountTransactions(config, ledger, rules, account, accConfig)
+	imports := backfillImports(ops)
+	result := executeSyncOps(config, ledger, imports)
+	log.Printf("Backfill complete. %d new transactions added, %d pending transactions replaced by their posted versions, %d skipped (already imported or pending), %d not imported.",
+		result.Created, result.Updated, len(account.Transactions)-len(imports)-deferred, result.Failed+deferred)
+	if result.Failed > 0 || deferred > 0 {
+		os.Exit(exitPartialFailure)
+	}
+}
+
+// backfillImports keeps the planned writes that import what is missing: new posted
+// transactions, and posted versions of imported pending ones, which would otherwise
+// never replace them. Revisions and expired pending transactions are left to the
+// regular sync.
+func backfillImports(ops []syncOp) []syncOp {
+	var imports []syncOp
+	for _, op := range ops {
+		if (op.kind == opCreate && !op.tx.Pending) || op.kind == opReplacePending {
+			imports = append(imports, op)
+		}
+	}
+	return imports
+}
+
+// findMappedAccount looks up a mapped account by SimpleFIN ID or, failing that, by its configured name
+func findMappedAccount(config Config, query string) (string, AccountConfig, bool) {
+	if accConfig, ok := config.AccountMap[query]; ok {
+		return query, accConfig, true
+	}
+	for id, accConfig := range config.AccountMap {
+		if strings.EqualFold(accConfig.Name, query) {
+			return id, accConfig, true
+		}
+	}
+	return "", AccountConfig{}, false
+}
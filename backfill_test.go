@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestBackfillImports(t *testing.T) {
+	ops := []syncOp{
+		{kind: opCreate, tx: SFTransaction{ID: "new"}},
+		{kind: opCreate, tx: SFTransaction{ID: "pending", Pending: true}},
+		{kind: opUpdate, tx: SFTransaction{ID: "revised"}},
+		{kind: opReplacePending, tx: SFTransaction{ID: "posted"}},
+		{kind: opDeletePending, previous: LedgerEntry{TransactionID: "expired"}},
+	}
+	var got []string
+	for _, op := range backfillImports(ops) {
+		got = append(got, op.tx.ID)
+	}
+	if len(got) != 2 || got[0] != "new" || got[1] != "posted" {
+		t.Errorf("backfillImports kept %v, want [new posted]", got)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// SyncAccountBalance pushes the SimpleFIN balance of an account to Sure as a
+// valuation. It returns false without calling Sure when the balance and
+// balance-date are unchanged since the last push.
+func SyncAccountBalance(config Config, accConfig AccountConfig, account SFAccount, syncState map[string]AccountSyncState) (bool, error) {
+	state := syncState[account.ID]
+	if state.LastBalance == account.Balance && state.LastBalanceDate == account.BalanceDate {
+		return false, nil
+	}
+
+	if err := pushAccountBalance(config, accConfig, account, syncState); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pushAccountBalance posts the SimpleFIN balance as a valuation, with the sign Sure
+// expects for the account, and records it in the sync state
+func pushAccountBalance(config Config, accConfig AccountConfig, account SFAccount, syncState map[string]AccountSyncState) error {
+	valuation := SureValuation{
+		AccountID: accConfig.SureID,
+		Amount:    accConfig.sureBalance(account.Balance),
+		Date:      balanceTime(account).Format("2006-01-02"),
+		Notes:     fmt.Sprintf("Balance imported via SimpleFIN. Account ID: %s", account.ID),
+	}
+	if err := CreateSureValuation(config.SureBaseURL, config.SureAPIKey, valuation); err != nil {
+		return err
+	}
+
+	state := syncState[account.ID]
+	state.LastBalance = account.Balance
+	state.LastBalanceDate = account.BalanceDate
+	syncState[account.ID] = state
+	log.Printf("Pushed balance for %s: %s as of %s", accConfig.Name, valuation.Amount, valuation.Date)
+	return nil
+}
+
+// balanceTime returns the time the SimpleFIN balance was reported, or now if unknown
+func balanceTime(account SFAccount) time.Time {
+	if account.BalanceDate == 0 {
+		return time.Now()
+	}
+	return time.Unix(int64(account.BalanceDate), 0)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// balancesCacheFile holds the last account list fetched from SimpleFIN
+const balancesCacheFile = "accounts.json"
+
+// CachedAccount holds cached account data with timestamp
+type CachedAccount struct {
+	Account   SFAccount `json:"account"`
+	FetchedAt time.Time `json:"fetched_at"`
+	StartDate int64     `json:"start_date,omitempty"` // Transaction window covered by Account.Transactions
+	EndDate   int64     `json:"end_date,omitempty"`
+}
+
+// CachedBalances holds the cached SimpleFIN account list with timestamp
+type CachedBalances struct {
+	Accounts  []SFAccount `json:"accounts"`
+	FetchedAt time.Time   `json:"fetched_at"`
+}
+
+// Fresh reports whether the cached account is younger than ttl
+func (c CachedAccount) Fresh(ttl time.Duration) bool {
+	return ttl > 0 && time.Since(c.FetchedAt) < ttl
+}
+
+// Fresh reports whether the cached account list is younger than ttl
+func (c CachedBalances) Fresh(ttl time.Duration) bool {
+	return ttl > 0 && time.Since(c.FetchedAt) < ttl
+}
+
+// LoadCachedAccount reads the cached data for a SimpleFIN account
+func LoadCachedAccount(accountID string) (CachedAccount, bool) {
+	var cached CachedAccount
+	data, err := os.ReadFile(accountCachePath(accountID))
+	if err != nil {
+		return cached, false
+	}
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return cached, false
+	}
+	return cached, true
+}
+
+// SaveCachedAccount caches an account and the transaction window it was fetched for
+func SaveCachedAccount(account SFAccount, startDate, endDate int64) error {
+	cached := CachedAccount{
+		Account:   account,
+		FetchedAt: time.Now(),
+		StartDate: startDate,
+		EndDate:   endDate,
+	}
+	data, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(accountCachePath(account.ID), data, 0644)
+}
+
+// LoadCachedBalances reads the cached SimpleFIN account list
+func LoadCachedBalances() (CachedBalances, bool) {
+	var cached CachedBalances
+	data, err := os.ReadFile(filepath.Join(cacheDir, balancesCacheFile))
+	if err != nil {
+		return cached, false
+	}
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return cached, false
+	}
+	return cached, true
+}
+
+// SaveCachedBalances caches the SimpleFIN account list
+func SaveCachedBalances(accounts []SFAccount) error {
+	cached := CachedBalances{
+		Accounts:  accounts,
+		FetchedAt: time.Now(),
+	}
+	data, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(cacheDir, balancesCacheFile), data, 0644)
+}
+
+// accountCachePath returns the cache file for a SimpleFIN account
+func accountCachePath(accountID string) string {
+	return filepath.Join(cacheDir, "account_"+accountID+".json")
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runAccounts dispatches the "accounts" subcommands
+func runAccounts(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: sure-simplefin-sync accounts list|map|unmap [flags]")
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "list":
+		runAccountsList(args[1:])
+	case "map":
+		runAccountsMap(args[1:])
+	case "unmap":
+		runAccountsUnmap(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown accounts command %q, expected list, map or unmap\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// runAccountsList prints the Sure accounts and the SimpleFIN accounts with their mappings
+func runAccountsList(args []string) {
+	fs := flag.NewFlagSet("accounts list", flag.ExitOnError)
+	refresh := fs.Bool("refresh", false, "Fetch the SimpleFIN account list instead of using the cached one (uses one request of the daily budget)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sure-simplefin-sync accounts list [--refresh]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	config := LoadConfig()
+	ConfigureHTTPClients(config)
+
+	sureAccounts, err := FetchSureAccounts(config.SureBaseURL, config.SureAPIKey)
+	if err != nil {
+		log.Fatalf("Failed to fetch Sure accounts: %v", err)
+	}
+	sureNames := make(map[string]string, len(sureAccounts))
+	fmt.Println("Sure accounts:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  NAME\tID\tTYPE\tBALANCE")
+	for _, acc := range sureAccounts {
+		sureNames[acc.ID] = acc.Name
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s %s\n", acc.Name, acc.ID, acc.AccountType, acc.Balance, acc.Currency)
+	}
+	w.Flush()
+
+	sfAccounts := simplefinAccountList(config, *refresh)
+	listed := make(map[string]bool)
+	fmt.Println("\nSimpleFIN accounts:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  NAME\tID\tINSTITUTION\tMAPPED TO")
+	for _, account := range sfAccounts {
+		listed[account.ID] = true
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", account.Name, account.ID, account.Org.Domain, describeMapping(config, account.ID, sureNames))
+	}
+	// Mapped accounts that SimpleFIN no longer reports (or that are not cached yet)
+	for _, id := range sortedAccountIDs(config) {
+		if !listed[id] {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", config.AccountMap[id].Name, id, "?", describeMapping(config, id, sureNames))
+		}
+	}
+	w.Flush()
+}
+
+// simplefinAccountList returns the SimpleFIN accounts from the balances cache, or
+// from SimpleFIN when refresh is set or nothing is cached
+func simplefinAccountList(config Config, refresh bool) []SFAccount {
+	if cached, ok := LoadCachedBalances(); ok && !refresh {
+		fmt.Printf("\n(SimpleFIN accounts as of %s, use --refresh to update)\n", cached.FetchedAt.Format(time.RFC3339))
+		return cached.Accounts
+	}
+	if config.AccessURL == "" {
+		log.Printf("No AccessURL in config.json, run the claim command to list SimpleFIN accounts")
+		return nil
+	}
+
+	quota := LoadRequestQuota(config.DailyRequestBudget())
+	if quota.Remaining() < 1 {
+		log.Printf("SimpleFIN daily request budget exhausted (%d requests made today)", quota.Requests)
+		return nil
+	}
+	fetcher := &simplefinFetcher{accessURL: config.AccessURL, quota: quota, workers: 1}
+	resp, err := fetcher.fetchAccounts(config.AccessURL + "/accounts?balances-only=1")
+	if err := quota.Save(); err != nil {
+		log.Printf("Warning: Failed to save SimpleFIN request count: %v", err)
+	}
+	if err != nil {
+		log.Printf("Failed to fetch SimpleFIN accounts: %v", err)
+		return nil
+	}
+	if err := SaveCachedBalances(resp.Accounts); err != nil {
+		log.Printf("Warning: Failed to cache account balances: %v", err)
+	}
+	return resp.Accounts
+}
+
+// describeMapping summarizes how a SimpleFIN account is mapped
+func describeMapping(config Config, accountID string, sureNames map[string]string) string {
+	accConfig, ok := config.AccountMap[accountID]
+	if !ok {
+		return "-"
+	}
+	name, found := sureNames[accConfig.SureID]
+	if !found {
+		name = "missing in Sure"
+	}
+	desc := fmt.Sprintf("%s (%s)", name, accConfig.SureID)
+	var flags []string
+	if accConfig.BalanceOnly {
+		flags = append(flags, "balance only")
+	}
+	if accConfig.Closed {
+		flags = append(flags, "closed")
+	}
+	if accConfig.Holdings != "" {
+		flags = append(flags, "holdings: "+accConfig.Holdings)
+	}
+	if len(flags) > 0 {
+		desc += " [" + strings.Join(flags, ", ") + "]"
+	}
+	return desc
+}
+
+// sortedAccountIDs returns the mapped SimpleFIN account IDs in a stable order
+func sortedAccountIDs(config Config) []string {
+	ids := make([]string, 0, len(config.AccountMap))
+	for id := range config.AccountMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// runAccountsMap maps a SimpleFIN account to an existing Sure account
+func runAccountsMap(args []string) {
+	fs := flag.NewFlagSet("accounts map", flag.ExitOnError)
+	name := fs.String("name", "", "Name to record for the account (default the Sure account name)")
+	balanceOnly := fs.Bool("balance-only", false, "Sync only the balance of the account, not its transactions")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sure-simplefin-sync accounts map [flags] <simplefin-account-id> <sure-account-id>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	sfID, sureID := fs.Arg(0), fs.Arg(1)
+
+	config := LoadConfig()
+	ConfigureHTTPClients(config)
+
+	sureAccounts, err := FetchSureAccounts(config.SureBaseURL, config.SureAPIKey)
+	if err != nil {
+		log.Fatalf("Failed to fetch Sure accounts: %v", err)
+	}
+	var sureAcc *SureAccount
+	for i := range sureAccounts {
+		if sureAccounts[i].ID == sureID {
+			sureAcc = &sureAccounts[i]
+		}
+	}
+	if sureAcc == nil {
+		log.Fatalf("Sure account %s not found, see \"accounts list\"", sureID)
+	}
+
+	accConfig := config.AccountMap[sfID] // Keep the other settings when remapping
+	if previous, ok := config.AccountMap[sfID]; ok && previous.SureID != sureID {
+		log.Printf("Remapping SimpleFIN account %s from Sure account %s", sfID, previous.SureID)
+	}
+	accConfig.SureID = sureID
+	accConfig.Name = sureAcc.Name
+	if *name != "" {
+		accConfig.Name = *name
+	}
+	if *balanceOnly {
+		accConfig.BalanceOnly = true
+	}
+	if config.AccountMap == nil {
+		config.AccountMap = make(map[string]AccountConfig)
+	}
+	config.AccountMap[sfID] = accConfig
+	if err := SaveConfig(config); err != nil {
+		log.Fatalf("Failed to save config: %v", err)
+	}
+	log.Printf("Mapped SimpleFIN account %s to Sure account %s (%s)", sfID, accConfig.Name, sureID)
+}
+
+// runAccountsUnmap removes the mapping of a SimpleFIN account
+func runAccountsUnmap(args []string) {
+	fs := flag.NewFlagSet("accounts unmap", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sure-simplefin-sync accounts unmap <simplefin-account-id>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	config := LoadConfig()
+	sfID := fs.Arg(0)
+	accConfig, ok := config.AccountMap[sfID]
+	if !ok {
+		log.Fatalf("SimpleFIN account %s is not mapped", sfID)
+	}
+	delete(config.AccountMap, sfID)
+	if err := SaveConfig(config); err != nil {
+		log.Fatalf("Failed to save config: %v", err)
+	}
+	log.Printf("Unmapped SimpleFIN account %s (%s). Its transactions stay in Sure and in the ledger.", sfID, accConfig.Name)
+}
+
+// runStatus prints the sync state of every mapped account without contacting either service
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sure-simplefin-sync status")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	config := LoadConfig()
+	syncState := LoadAccountSyncState()
+
+	// Read-only, so status neither creates the ledger nor migrates sync_state.json
+	_, statErr := os.Stat(ledgerFile)
+	ledgerMissing := os.IsNotExist(statErr)
+	ledger, err := OpenLedgerReadOnly(ledgerFile)
+	if err != nil {
+		log.Fatalf("Failed to open transaction ledger: %v", err)
+	}
+	defer ledger.Close()
+	counts, err := ledger.AccountCounts()
+	if err != nil {
+		log.Printf("Warning: Failed to count ledger transactions: %v", err)
+	}
+	intents, err := ledger.Intents()
+	if err != nil {
+		log.Printf("Warning: Failed to read unresolved imports: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tSIMPLEFIN ID\tLAST SYNC\tLAST BALANCE\tTRANSACTIONS\tMODE")
+	for _, id := range sortedAccountIDs(config) {
+		accConfig := config.AccountMap[id]
+		state := syncState[id]
+		lastSync := "never"
+		if state.LastSyncDate != 0 {
+			lastSync = time.Unix(state.LastSyncDate, 0).Format("2006-01-02 15:04")
+		}
+		lastBalance := "-"
+		if state.LastBalance != "" {
+			lastBalance = state.LastBalance
+		}
+		mode := "transactions"
+		switch {
+		case accConfig.Closed:
+			mode = "closed"
+		case accConfig.BalanceOnly:
+			mode = "balance only"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", accConfig.Name, id, lastSync, lastBalance, counts[id], mode)
+	}
+	w.Flush()
+
+	if ledgerMissing {
+		fmt.Printf("\nLedger: %s not found, nothing has been imported yet\n", ledgerFile)
+	} else {
+		fmt.Printf("\nLedger: %d transactions", ledger.Count())
+		if len(intents) > 0 {
+			fmt.Printf(", %d interrupted imports to be checked on the next sync", len(intents))
+		}
+		fmt.Println()
+	}
+
+	quota := LoadRequestQuota(config.DailyRequestBudget())
+	if config.DailyRequestBudget() < 0 {
+		fmt.Printf("SimpleFIN requests today: %d (no budget)\n", quota.Requests)
+	} else {
+		fmt.Printf("SimpleFIN requests today: %d of %d\n", quota.Requests, config.DailyRequestBudget())
+	}
+	if cached, ok := LoadCachedBalances(); ok {
+		fmt.Printf("Cached SimpleFIN accounts: %s old\n", formatAge(time.Since(cached.FetchedAt)))
+	}
+	if config.AccessURL == "" {
+		fmt.Println("SimpleFIN access URL: not claimed yet")
+	}
+}
+
+// runRules dispatches the "rules" subcommands
+func runRules(args []string) {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprintln(os.Stderr, "Usage: sure-simplefin-sync rules test <simplefin-transaction-id>")
+		os.Exit(1)
+	}
+	fs := flag.NewFlagSet("rules test", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sure-simplefin-sync rules test <simplefin-transaction-id>")
+		fmt.Fprintln(fs.Output(), "Evaluates rules.json against a transaction from the tmp/ cache without contacting Sure.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	config := LoadConfig()
+	rules, err := LoadRules()
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", rulesFile, err)
+	}
+	if rules == nil {
+		log.Fatalf("No %s found", rulesFile)
+	}
+
+	txID := fs.Arg(0)
+	found, ok := loadCachedTransactions()[txID]
+	if !ok {
+		log.Fatalf("Transaction %s not found in the %s/ cache, run a sync first", txID, cacheDir)
+	}
+	cached, _ := LoadCachedAccount(found.accountID)
+	account := cached.Account
+	tx := found.tx
+	resolveAmountSigns(config, nil) // Sure is not contacted, "auto" accounts keep the SimpleFIN sign
+	accConfig := config.AccountMap[account.ID]
+	if accConfig.InvertAmounts == InvertAuto {
+		fmt.Println("Note: invert_amounts is auto, amounts are shown as SimpleFIN reports them")
+	}
+
+	fmt.Printf("Transaction %s on %s (%s, %s)\n", tx.ID, account.Name, account.ID, account.Org.Domain)
+	fmt.Printf("  Description: %s\n  Payee:       %s\n  Amount:      %s\n", tx.Description, tx.Payee, tx.Amount)
+	fmt.Printf("  Name:        %s\n\n", buildSureTransaction(config, account, accConfig, tx).Name)
+
+	var matched *Rule
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if reason := rule.mismatch(tx, account, accConfig); reason != "" {
+			fmt.Printf("  no     %s: %s\n", rule.Name, reason)
+			continue
+		}
+		fmt.Printf("  MATCH  %s\n", rule.Name)
+		matched = rule
+		break
+	}
+
+	if matched == nil {
+		fmt.Println("\nNo rule matches, the transaction is imported uncategorized.")
+		return
+	}
+	a := matched.Actions
+	fmt.Printf("\nActions of %s:\n", matched.Name)
+	if a.Name != "" {
+		fmt.Printf("  Name:     %s\n", a.Name)
+	}
+	if a.Category != "" {
+		fmt.Printf("  Category: %s\n", a.Category)
+	}
+	if len(a.Tags) > 0 {
+		fmt.Printf("  Tags:     %s\n", strings.Join(a.Tags, ", "))
+	}
+	if a.Merchant != "" {
+		fmt.Printf("  Merchant: %s\n", a.Merchant)
+	}
+}
+
+// runMetadata dispatches the "metadata" subcommands
+func runMetadata(args []string) {
+	if len(args) == 0 || args[0] != "sync" {
+		fmt.Fprintln(os.Stderr, "Usage: sure-simplefin-sync metadata sync")
+		os.Exit(1)
+	}
+	fs := flag.NewFlagSet("metadata sync", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sure-simplefin-sync metadata sync")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+
+	config := LoadConfig()
+	ConfigureHTTPClients(config)
+	syncAccountMetadata(&config)
+	if err := SaveConfig(config); err != nil {
+		log.Fatalf("Failed to save config: %v", err)
+	}
+	log.Println("Metadata sync complete.")
+}
+
+// runClaim exchanges a SimpleFIN setup token for the permanent access URL and saves it
+func runClaim(args []string) {
+	fs := flag.NewFlagSet("claim", flag.ExitOnError)
+	token := fs.String("token", "", "SimpleFIN setup token (default setup_token from config.json)")
+	force := fs.Bool("force", false, "Replace an access URL that is already configured")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sure-simplefin-sync claim [--token <setup token>] [--force]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	config := LoadConfig()
+	ConfigureHTTPClients(config)
+	if config.AccessURL != "" && !*force {
+		log.Fatal("An access URL is already configured, use --force to replace it")
+	}
+	setupToken := *token
+	if setupToken == "" {
+		setupToken = config.SetupToken
+	}
+	if setupToken == "" {
+		log.Fatal("No setup token given, pass --token or set setup_token in config.json")
+	}
+
+	config.AccessURL = ClaimSimpleFINToken(setupToken)
+	config.SetupToken = setupToken
+	if err := SaveConfig(config); err != nil {
+		log.Fatalf("Failed to save config: %v", err)
+	}
+	log.Println("Successfully claimed and saved permanent Access URL.")
+}
+
+func syncAccountMetadata(config *Config) {
+	log.Println("Syncing account metadata from Sure...")
+	sureAccounts, err := FetchSureAccounts(config.SureBaseURL, config.SureAPIKey)
+	if err != nil {
+		log.Fatalf("Failed to fetch Sure accounts: %v", err)
+	}
+
+	sureAccountsMap := make(map[string]SureAccount)
+	for _, acc := range sureAccounts {
+		sureAccountsMap[acc.ID] = acc
+	}
+
+	updated := false
+	for sfID, accConfig := range config.AccountMap {
+		if sureAcc, ok := sureAccountsMap[accConfig.SureID]; ok {
+			if accConfig.Name != sureAcc.Name {
+				log.Printf("Updating name for account %s: %s -> %s", sfID, accConfig.Name, sureAcc.Name)
+				accConfig.Name = sureAcc.Name
+				config.AccountMap[sfID] = accConfig
+				updated = true
+			}
+		} else {
+			log.Printf("Warning: Mapped Sure account %s not found in Sure", accConfig.SureID)
+		}
+	}
+
+	if updated {
+		log.Println("Metadata updated.")
+	} else {
+		log.Println("No metadata changes detected.")
+	}
+}
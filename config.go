@@ -4,15 +4,64 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+	"text/template"
+	"time"
 )
 
 const configFile = "config.json"
 
+// Drift actions taken by the reconciliation pass when an account exceeds its threshold
+const (
+	DriftActionWarn    = "warn"    // Only flag the account in the report (default)
+	DriftActionFail    = "fail"    // Exit non-zero after the report
+	DriftActionCorrect = "correct" // Post a valuation to bring Sure in line with SimpleFIN
+)
+
+// Date sources for the date given to imported transactions
+const (
+	DateSourcePosted         = "posted"          // Posted date; pending transactions use transacted_at
+	DateSourceTransacted     = "transacted"      // transacted_at exactly as reported
+	DateSourcePostedFallback = "posted-fallback" // transacted_at, or posted when the institution reports 0 (default)
+)
+
 // AccountConfig holds configuration for a specific account mapping
 type AccountConfig struct {
-	SureID      string `json:"sure_id"`
-	Name        string `json:"name"`
-	BalanceOnly bool   `json:"balance_only,omitzero"`
+	SureID         string  `json:"sure_id"`
+	Name           string  `json:"name"`
+	BalanceOnly    bool    `json:"balance_only,omitzero"`
+	DriftThreshold float64 `json:"drift_threshold,omitzero"`  // Allowed absolute difference between SimpleFIN and Sure balances
+	DriftAction    string  `json:"drift_action,omitzero"`     // One of DriftActionWarn, DriftActionFail, DriftActionCorrect
+	IncludePending *bool   `json:"include_pending,omitempty"` // Overrides Config.IncludePending for this account
+	DateSource     string  `json:"date_source,omitzero"`      // Overrides Config.DateSource for this account
+	Holdings       string  `json:"holdings,omitzero"`         // Investment holdings sync: HoldingsModeValuation or HoldingsModeTrades
+	StartDate      string  `json:"start_date,omitzero"`       // YYYY-MM-DD, transactions dated earlier are never imported
+	EndDate        string  `json:"end_date,omitzero"`         // YYYY-MM-DD, transactions dated later are never imported
+	Closed         bool    `json:"closed,omitzero"`           // The account is closed and no longer synced at all
+
+	InvertAmounts InvertAmounts `json:"invert_amounts,omitzero"` // Flip the sign of amounts: false, true or "auto" for liabilities
+	NameTemplate  string        `json:"name_template,omitzero"`  // Overrides Config.NameTemplate for this account
+	NotesTemplate string        `json:"notes_template,omitzero"` // Overrides Config.NotesTemplate for this account
+
+	invert    bool // Resolved from InvertAmounts by resolveAmountSigns
+	liability bool // The Sure account is classified as a liability
+
+	nameTemplate, notesTemplate *template.Template
+}
+
+// InSyncRange reports whether a YYYY-MM-DD transaction date lies within start_date and end_date
+func (a AccountConfig) InSyncRange(date string) bool {
+	return date >= a.StartDate && (a.EndDate == "" || date <= a.EndDate)
+}
+
+// clampWindow narrows a fetch window (Unix timestamps) to start_date and end_date
+func (a AccountConfig) clampWindow(start, end int64) (int64, int64) {
+	if day, err := time.Parse("2006-01-02", a.StartDate); err == nil {
+		start = max(start, day.Unix())
+	}
+	if day, err := time.Parse("2006-01-02", a.EndDate); err == nil {
+		end = min(end, day.AddDate(0, 0, 1).Unix())
+	}
+	return start, end
 }
 
 // Config holds the application configuration
@@ -22,6 +71,107 @@ type Config struct {
 	AccessURL   string                   `json:"access_url"`    // The permanent SimpleFIN URL
 	SetupToken  string                   `json:"setup_token"`   // Used only once if AccessURL is empty
 	AccountMap  map[string]AccountConfig `json:"account_map"`   // Maps SimpleFIN ID -> AccountConfig
+
+	IncludePending    bool `json:"include_pending,omitzero"`     // Import pending transactions
+	PendingExpiryDays int  `json:"pending_expiry_days,omitzero"` // Days before a vanished pending transaction is deleted
+
+	DateSource string `json:"date_source,omitzero"` // One of DateSourcePosted, DateSourceTransacted, DateSourcePostedFallback
+
+	LookbackDays int `json:"lookback_days,omitzero"` // Days before the last sync re-fetched every run (default 14, negative disables)
+
+	Normalize NormalizeConfig `json:"normalize,omitzero"` // Clean-up of payees and descriptions used as transaction names
+
+	NameTemplate  string `json:"name_template,omitzero"`  // text/template for transaction names, see TransactionTemplateData
+	NotesTemplate string `json:"notes_template,omitzero"` // text/template for transaction notes, must contain "ID: {{.ID}}"
+	nameTemplate  *template.Template
+	notesTemplate *template.Template
+
+	Transfers TransferConfig `json:"transfers,omitzero"` // Detection of transfers between mapped accounts
+
+	SureTimeoutSeconds      int `json:"sure_timeout_seconds,omitzero"`      // Per-request timeout for Sure (default 30)
+	SimpleFINTimeoutSeconds int `json:"simplefin_timeout_seconds,omitzero"` // Per-request timeout for SimpleFIN (default 60)
+	MaxRetries              int `json:"max_retries,omitzero"`               // Retries of transient HTTP failures (default 3, negative disables)
+
+	SimpleFINDailyBudget int `json:"simplefin_daily_budget,omitzero"` // SimpleFIN requests allowed per day (default 24, negative for unlimited)
+	CacheTTLMinutes      int `json:"cache_ttl_minutes,omitzero"`      // Reuse SimpleFIN data cached in tmp/ for this long (default 0, disabled)
+	FetchWorkers         int `json:"fetch_workers,omitzero"`          // Accounts fetched from SimpleFIN in parallel (default 4)
+
+	PostWorkers   int     `json:"post_workers,omitzero"`    // Transactions posted to Sure in parallel (default 4)
+	SureRateLimit float64 `json:"sure_rate_limit,omitzero"` // Maximum Sure requests per second (default 0, unlimited)
+}
+
+const (
+	defaultPendingExpiryDays = 10
+	defaultFetchWorkers      = 4
+	defaultPostWorkers       = 4
+	defaultLookbackDays      = 14
+)
+
+// PendingEnabled reports whether pending transactions are imported for a SimpleFIN account
+func (c Config) PendingEnabled(accountID string) bool {
+	if accConfig, ok := c.AccountMap[accountID]; ok && accConfig.IncludePending != nil {
+		return *accConfig.IncludePending
+	}
+	return c.IncludePending
+}
+
+// DateSourceFor returns the date source policy for a SimpleFIN account
+func (c Config) DateSourceFor(accountID string) string {
+	if accConfig, ok := c.AccountMap[accountID]; ok && accConfig.DateSource != "" {
+		return accConfig.DateSource
+	}
+	if c.DateSource != "" {
+		return c.DateSource
+	}
+	return DateSourcePostedFallback
+}
+
+// DailyRequestBudget returns how many SimpleFIN requests may be made per day, negative for unlimited
+func (c Config) DailyRequestBudget() int {
+	if c.SimpleFINDailyBudget == 0 {
+		return defaultDailyRequestBudget
+	}
+	return c.SimpleFINDailyBudget
+}
+
+// CacheTTL returns how long cached SimpleFIN data is reused
+func (c Config) CacheTTL() time.Duration {
+	return time.Duration(c.CacheTTLMinutes) * time.Minute
+}
+
+// FetchWorkerCount returns how many SimpleFIN accounts may be fetched in parallel
+func (c Config) FetchWorkerCount() int {
+	if c.FetchWorkers <= 0 {
+		return defaultFetchWorkers
+	}
+	return c.FetchWorkers
+}
+
+// PostWorkerCount returns how many transactions may be posted to Sure in parallel
+func (c Config) PostWorkerCount() int {
+	if c.PostWorkers <= 0 {
+		return defaultPostWorkers
+	}
+	return c.PostWorkers
+}
+
+// Lookback returns how far before the last sync date each fetch starts. A negative
+// lookback_days disables the overlap.
+func (c Config) Lookback() time.Duration {
+	days := c.LookbackDays
+	if days == 0 {
+		days = defaultLookbackDays
+	}
+	return time.Duration(max(days, 0)) * 24 * time.Hour
+}
+
+// PendingExpiry returns how long a pending transaction may be missing from SimpleFIN before it is deleted
+func (c Config) PendingExpiry() time.Duration {
+	days := c.PendingExpiryDays
+	if days <= 0 {
+		days = defaultPendingExpiryDays
+	}
+	return time.Duration(days) * 24 * time.Hour
 }
 
 // LoadConfig reads the configuration from disk
@@ -33,9 +183,10 @@ func LoadConfig() Config {
 
 	// Try loading with the new structure
 	var cfg Config
-	if err := json.Unmarshal(file, &cfg); err == nil && len(cfg.AccountMap) > 0 {
-		// Check if it's actually the new format by looking at one entry
-		isNewFormat := false
+	if err := json.Unmarshal(file, &cfg); err == nil && (len(cfg.AccountMap) > 0 || cfg.SureBaseURL != "") {
+		// Check if it's actually the new format by looking at one entry. An empty
+		// map (e.g. after unmapping every account) has nothing to migrate.
+		isNewFormat := len(cfg.AccountMap) == 0
 		for _, v := range cfg.AccountMap {
 			if v.SureID != "" {
 				isNewFormat = true
@@ -43,6 +194,13 @@ func LoadConfig() Config {
 			break
 		}
 		if isNewFormat {
+			validateAccountDates(cfg)
+			if err := cfg.Normalize.compile(); err != nil {
+				log.Fatalf("Invalid normalize settings in %s: %v", configFile, err)
+			}
+			if err := cfg.compileTemplates(); err != nil {
+				log.Fatalf("Invalid template in %s: %v", configFile, err)
+			}
 			return cfg
 		}
 	}
@@ -95,6 +253,17 @@ func LoadConfig() Config {
 	return cfg
 }
 
+// validateAccountDates stops with an error if an account's start_date or end_date is not YYYY-MM-DD
+func validateAccountDates(cfg Config) {
+	for id, accConfig := range cfg.AccountMap {
+		for field, value := range map[string]string{"start_date": accConfig.StartDate, "end_date": accConfig.EndDate} {
+			if _, err := time.Parse("2006-01-02", value); value != "" && err != nil {
+				log.Fatalf("Invalid %s %q for account %s in %s, expected YYYY-MM-DD", field, value, id, configFile)
+			}
+		}
+	}
+}
+
 // SaveConfig writes the configuration to disk
 func SaveConfig(cfg Config) error {
 	data, err := json.MarshalIndent(cfg, "", "  ")
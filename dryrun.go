@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Dry-run output formats
+const (
+	DryRunOutputTable = "table"
+	DryRunOutputJSON  = "json"
+)
+
+// dryRun collects the writes of a --dry-run sync. It is nil during a normal run;
+// when set, the functions that write to Sure record what they would send here
+// instead of calling the API.
+var dryRun *DryRunReport
+
+// reportOutput is where a sync prints its reports and prompts. A dry run keeps
+// stdout for the plan, so they go to stderr instead.
+func reportOutput() io.Writer {
+	if dryRun != nil {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// PlannedWrite is one write a dry run would have sent to Sure
+type PlannedWrite struct {
+	Action        string                    `json:"action"`                    // e.g. create_transaction, update_transaction, create_valuation
+	SureID        string                    `json:"sure_id,omitempty"`         // Existing Sure transaction that is updated or deleted
+	SureAccountID string                    `json:"sure_account_id,omitempty"` // Account of an updated transaction
+	Changes       []string                  `json:"changes,omitempty"`         // Bank revisions that cause an update
+	Transaction   *SureTransaction          `json:"transaction,omitempty"`
+	Update        *SureTransactionUpdate    `json:"update,omitempty"` // Fields an update sends
+	Valuation     *SureValuation            `json:"valuation,omitempty"`
+	Trade         *SureTrade                `json:"trade,omitempty"`
+	Transfer      *SureTransfer             `json:"transfer,omitempty"`
+	Account       *CreateSureAccountRequest `json:"account,omitempty"`
+}
+
+// DryRunReport is the list of planned writes, in the order the sync made them
+type DryRunReport struct {
+	mu     sync.Mutex
+	Writes []PlannedWrite `json:"writes"`
+}
+
+func (r *DryRunReport) record(w PlannedWrite) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Writes = append(r.Writes, w)
+}
+
+// recordOps records planned transaction writes and counts them as if they had succeeded
+func (r *DryRunReport) recordOps(ops []syncOp) TransactionSyncResult {
+	var result TransactionSyncResult
+	for _, op := range ops {
+		payload := op.payload
+		switch op.kind {
+		case opCreate:
+			r.record(PlannedWrite{Action: "create_transaction", Transaction: &payload})
+			result.Created++
+		case opUpdate:
+			update := op.update
+			r.record(PlannedWrite{Action: "update_transaction", SureID: op.previous.SureTransactionID, SureAccountID: payload.AccountID,
+				Changes: op.changes, Update: &update})
+			result.Updated++
+		case opReplacePending:
+			update := op.update
+			changes := []string{"pending " + op.previous.TransactionID + " -> posted " + op.tx.ID}
+			r.record(PlannedWrite{Action: "update_transaction", SureID: op.previous.SureTransactionID, SureAccountID: payload.AccountID,
+				Changes: changes, Update: &update})
+			result.Updated++
+		case opDeletePending:
+			r.record(PlannedWrite{Action: "delete_transaction", SureID: op.previous.SureTransactionID,
+				Changes: []string{"pending " + op.previous.TransactionID + " expired"}})
+			result.Deleted++
+		}
+	}
+	return result
+}
+
+// Print writes the report as a table or as JSON. Sure account IDs are shown with
+// the names from the account map.
+func (r *DryRunReport) Print(out io.Writer, format string, config Config) error {
+	if format == DryRunOutputJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+
+	accountNames := make(map[string]string)
+	for _, accConfig := range config.AccountMap {
+		accountNames[accConfig.SureID] = accConfig.Name
+	}
+	accountName := func(id string) string {
+		if name, ok := accountNames[id]; ok {
+			return name
+		}
+		return id
+	}
+
+	fmt.Fprintf(out, "Dry run: %d writes would be sent to Sure\n\n", len(r.Writes))
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tACCOUNT\tDATE\tAMOUNT\tDETAILS")
+	for _, pw := range r.Writes {
+		switch {
+		case pw.Transaction != nil:
+			tx := pw.Transaction
+			details := tx.Name
+			if len(pw.Changes) > 0 {
+				details += " (" + strings.Join(pw.Changes, ", ") + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pw.Action, accountName(tx.AccountID), tx.Date, tx.Amount, details)
+		case pw.Update != nil:
+			u := pw.Update
+			details := strings.Join(pw.Changes, ", ")
+			if u.Name != "" {
+				details = u.Name + " (" + details + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pw.Action, accountName(pw.SureAccountID), u.Date, u.Amount, details)
+		case pw.Valuation != nil:
+			v := pw.Valuation
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pw.Action, accountName(v.AccountID), v.Date, v.Amount, "balance")
+		case pw.Trade != nil:
+			t := pw.Trade
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s %s %s @ %s\n", pw.Action, accountName(t.AccountID), t.Date, "", t.Type, t.Qty, t.Ticker, t.Price)
+		case pw.Transfer != nil:
+			t := pw.Transfer
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pw.Action, accountName(t.FromAccountID), t.Date, t.Amount, "to "+accountName(t.ToAccountID))
+		case pw.Account != nil:
+			a := pw.Account.Account
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s %s, %s\n", pw.Action, a.Name, "", "", a.AccountableType, a.SubType, a.Currency)
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pw.Action, "", "", "", "Sure ID "+pw.SureID+": "+strings.Join(pw.Changes, ", "))
+		}
+	}
+	return w.Flush()
+}
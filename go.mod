@@ -3,7 +3,12 @@ module github.com/JamesReate/sure-simplefin-sync
 go 1.25.0
 
 require (
-	github.com/tidwall/gjson v1.18.0 // indirect
+	github.com/tidwall/gjson v1.18.0
+	go.etcd.io/bbolt v1.4.3
+)
+
+require (
 	github.com/tidwall/match v1.2.0 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
+	golang.org/x/sys v0.29.0 // indirect
 )
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Holdings sync modes for investment accounts
+const (
+	HoldingsModeValuation = "valuation" // Post an account valuation whenever positions change
+	HoldingsModeTrades    = "trades"    // Post share changes as trades, then a valuation
+)
+
+// SyncAccountHoldings compares the account's SimpleFIN holdings with the positions
+// recorded on the previous run and pushes any change to Sure. An empty list is
+// taken as holdings not reported this run, not as every position being sold. The
+// first positions seen in trades mode only seed the snapshot: they were bought
+// before the sync started, so no trades are posted for them.
+func SyncAccountHoldings(config Config, accConfig AccountConfig, account SFAccount, syncState map[string]AccountSyncState) error {
+	state := syncState[account.ID]
+	current := snapshotHoldings(account.Holdings)
+	if len(current) == 0 && accConfig.BalanceOnly {
+		log.Printf("Warning: holdings is set for %s but balance_only is too, and SimpleFIN reported no positions with the balances. Unset balance_only to sync holdings.", accConfig.Name)
+		return nil
+	}
+	if len(current) == 0 && len(state.Holdings) > 0 {
+		log.Printf("No holdings reported for %s this run, keeping the previous positions", accConfig.Name)
+		return nil
+	}
+	if maps.Equal(state.Holdings, current) {
+		return nil
+	}
+
+	date := balanceTime(account).Format("2006-01-02")
+	currency := ResolveCurrency(account.Currency)
+
+	if accConfig.Holdings == HoldingsModeTrades && len(state.Holdings) == 0 {
+		log.Printf("Recorded the starting positions of %s, trades are posted for changes from the next run", accConfig.Name)
+	} else if accConfig.Holdings == HoldingsModeTrades {
+		for _, trade := range holdingTrades(state.Holdings, current) {
+			trade.AccountID = accConfig.SureID
+			trade.Date = date
+			trade.Currency = currency
+			if err := CreateSureTrade(config.SureBaseURL, config.SureAPIKey, trade); err != nil {
+				return fmt.Errorf("trade %s %s %s: %w", trade.Type, trade.Qty, trade.Ticker, err)
+			}
+			log.Printf("Recorded %s of %s %s for %s", trade.Type, trade.Qty, trade.Ticker, accConfig.Name)
+		}
+	}
+
+	valuation := SureValuation{
+		AccountID: accConfig.SureID,
+		Amount:    account.Balance,
+		Date:      date,
+		Notes:     "Holdings imported via SimpleFIN:\n" + describeHoldings(current),
+	}
+	if err := CreateSureValuation(config.SureBaseURL, config.SureAPIKey, valuation); err != nil {
+		return err
+	}
+
+	state.Holdings = current
+	state.LastBalance = account.Balance
+	state.LastBalanceDate = account.BalanceDate
+	syncState[account.ID] = state
+	log.Printf("Synced %d holdings for %s, valued at %s", len(current), accConfig.Name, account.Balance)
+	return nil
+}
+
+// snapshotHoldings indexes holdings by ID, falling back to the symbol
+func snapshotHoldings(holdings []SFHolding) map[string]HoldingSnapshot {
+	snapshot := make(map[string]HoldingSnapshot, len(holdings))
+	for _, h := range holdings {
+		key := h.ID
+		if key == "" {
+			key = h.Symbol
+		}
+		snapshot[key] = HoldingSnapshot{
+			Symbol:      h.Symbol,
+			Shares:      h.Shares,
+			MarketValue: h.MarketValue,
+			CostBasis:   h.CostBasis,
+		}
+	}
+	return snapshot
+}
+
+// holdingTrades derives the buys and sells that turn the previous positions into the current ones
+func holdingTrades(previous, current map[string]HoldingSnapshot) []SureTrade {
+	keys := make([]string, 0, len(previous)+len(current))
+	for key := range previous {
+		keys = append(keys, key)
+	}
+	for key := range current {
+		if _, ok := previous[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var trades []SureTrade
+	for _, key := range keys {
+		before, after := previous[key], current[key]
+		beforeShares, _ := strconv.ParseFloat(before.Shares, 64)
+		afterShares, _ := strconv.ParseFloat(after.Shares, 64)
+		delta := afterShares - beforeShares
+		if math.Abs(delta) < 1e-9 {
+			continue
+		}
+
+		trade := SureTrade{Ticker: after.Symbol, Type: "buy"}
+		if trade.Ticker == "" {
+			trade.Ticker = before.Symbol
+		}
+		if delta < 0 {
+			trade.Type = "sell"
+		}
+		trade.Qty = strconv.FormatFloat(math.Abs(delta), 'f', -1, 64)
+		trade.Price = sharePrice(after, before)
+		trades = append(trades, trade)
+	}
+	return trades
+}
+
+// sharePrice estimates the per-share price from the market value of a position
+func sharePrice(positions ...HoldingSnapshot) string {
+	for _, p := range positions {
+		shares, err1 := strconv.ParseFloat(p.Shares, 64)
+		value, err2 := strconv.ParseFloat(p.MarketValue, 64)
+		if err1 == nil && err2 == nil && shares != 0 {
+			return strconv.FormatFloat(value/shares, 'f', 4, 64)
+		}
+	}
+	return "0"
+}
+
+// describeHoldings renders positions one per line, sorted by symbol
+func describeHoldings(snapshot map[string]HoldingSnapshot) string {
+	lines := make([]string, 0, len(snapshot))
+	for _, h := range snapshot {
+		lines = append(lines, fmt.Sprintf("%s: %s shares, value %s", h.Symbol, h.Shares, h.MarketValue))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
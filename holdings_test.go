@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestSyncAccountHoldingsTrades(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	config := Config{SureBaseURL: server.URL}
+	accConfig := AccountConfig{SureID: "s-brk", Name: "Brokerage", Holdings: HoldingsModeTrades}
+	account := SFAccount{ID: "brk", Balance: "1500.00", BalanceDate: 1_717_243_200,
+		Holdings: []SFHolding{{ID: "h1", Symbol: "VTI", Shares: "10", MarketValue: "1500.00"}}}
+	syncState := make(map[string]AccountSyncState)
+
+	steps := []struct {
+		name     string
+		holdings []SFHolding
+		want     []string
+	}{
+		{"first run seeds the snapshot", account.Holdings, []string{"POST /valuations"}},
+		{"unchanged", account.Holdings, nil},
+		{"shares bought", []SFHolding{{ID: "h1", Symbol: "VTI", Shares: "12", MarketValue: "1800.00"}}, []string{"POST /trades", "POST /valuations"}},
+		{"not reported", nil, nil},
+	}
+	for _, step := range steps {
+		calls = nil
+		account.Holdings = step.holdings
+		if err := SyncAccountHoldings(config, accConfig, account, syncState); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if !slices.Equal(calls, step.want) {
+			t.Errorf("%s: requests %v, want %v", step.name, calls, step.want)
+		}
+	}
+	if got := syncState["brk"].Holdings["h1"].Shares; got != "12" {
+		t.Errorf("snapshot holds %s shares, want 12", got)
+	}
+}
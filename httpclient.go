@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSureTimeout      = 30 * time.Second
+	defaultSimpleFINTimeout = 60 * time.Second
+	defaultMaxRetries       = 3
+	retryBaseDelay          = 1 * time.Second
+	retryMaxDelay           = 30 * time.Second
+	// Longest Retry-After we are willing to wait; anything longer is returned to the caller
+	maxRetryAfter = 2 * time.Minute
+)
+
+// RetryClient is an HTTP client for one service with a request timeout and
+// bounded, jittered retries of transient failures
+type RetryClient struct {
+	Name       string
+	Client     *http.Client
+	MaxRetries int
+
+	limiter *rateLimiter // Caps the request rate, including retries
+}
+
+// Shared clients for the two services this tool talks to, see ConfigureHTTPClients
+var (
+	sureClient      = &RetryClient{Name: "Sure", Client: &http.Client{Timeout: defaultSureTimeout}, MaxRetries: defaultMaxRetries}
+	simplefinClient = &RetryClient{Name: "SimpleFIN", Client: &http.Client{Timeout: defaultSimpleFINTimeout}, MaxRetries: defaultMaxRetries}
+)
+
+// ConfigureHTTPClients applies the timeouts, retry limit and Sure rate cap from the config to the shared clients
+func ConfigureHTTPClients(config Config) {
+	sureClient.limiter = newRateLimiter(config.SureRateLimit)
+	if config.SureTimeoutSeconds > 0 {
+		sureClient.Client.Timeout = time.Duration(config.SureTimeoutSeconds) * time.Second
+	}
+	if config.SimpleFINTimeoutSeconds > 0 {
+		simplefinClient.Client.Timeout = time.Duration(config.SimpleFINTimeoutSeconds) * time.Second
+	}
+	if config.MaxRetries != 0 {
+		retries := max(config.MaxRetries, 0) // Negative disables retries
+		sureClient.MaxRetries = retries
+		simplefinClient.MaxRetries = retries
+	}
+}
+
+// Get issues a GET request
+func (c *RetryClient) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends the request, retrying network errors and retryable statuses. Requests
+// that are not idempotent (POST) are only retried when the server explicitly
+// refused them (429, 503), so a transaction is never created twice.
+func (c *RetryClient) Do(req *http.Request) (*http.Response, error) {
+	return c.do(req, nil)
+}
+
+// GetCounted issues a GET request like Get, calling allow before every attempt so
+// that each one, retries included, can be counted. allow is told whether the attempt
+// is a retry; when it refuses a retry, the last response or error is returned.
+func (c *RetryClient) GetCounted(url string, allow func(retry bool) bool) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, allow)
+}
+
+// do sends the request with retries, asking allow (if set) before each attempt
+func (c *RetryClient) do(req *http.Request, allow func(retry bool) bool) (*http.Response, error) {
+	if allow != nil && !allow(false) {
+		return nil, fmt.Errorf("%s request to %s not allowed", c.Name, req.URL.Redacted())
+	}
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		c.limiter.Wait()
+		resp, err := c.Client.Do(attemptReq)
+		retry, reason := shouldRetry(req.Method, resp, err)
+		if !retry || attempt >= c.MaxRetries {
+			return resp, err
+		}
+
+		delay := backoffDelay(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				if after > maxRetryAfter {
+					return resp, err
+				}
+				delay = after
+			}
+		}
+		if allow != nil && !allow(true) {
+			log.Printf("Not retrying %s %s %s, the request budget is used up: %s", c.Name, req.Method, req.URL.Redacted(), reason)
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		log.Printf("Retrying %s %s %s in %s (attempt %d/%d): %s",
+			c.Name, req.Method, req.URL.Redacted(), delay.Round(time.Millisecond), attempt+2, c.MaxRetries+1, reason)
+		time.Sleep(delay)
+	}
+}
+
+// shouldRetry decides whether a response or error is transient
+func shouldRetry(method string, resp *http.Response, err error) (bool, string) {
+	idempotent := method != "POST" && method != "PATCH"
+	if err != nil {
+		return idempotent, err.Error()
+	}
+
+	reason := fmt.Sprintf("status %d", resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true, reason
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent, reason
+	}
+	return false, ""
+}
+
+// backoffDelay returns an exponential delay with jitter for the given attempt
+func backoffDelay(attempt int) time.Duration {
+	delay := min(retryBaseDelay<<attempt, retryMaxDelay)
+	// Jitter between half and the full delay
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryAfter parses the Retry-After header as seconds or an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
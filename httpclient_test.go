@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := range 8 {
+		full := min(retryBaseDelay<<attempt, retryMaxDelay)
+		for range 20 {
+			if d := backoffDelay(attempt); d < full/2 || d > full {
+				t.Fatalf("backoffDelay(%d) = %s, want between %s and %s", attempt, d, full/2, full)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    time.Duration
+		ok      bool
+		inexact bool // Depends on the clock
+	}{
+		{"", 0, false, false},
+		{"7", 7 * time.Second, true, false},
+		{"0", 0, true, false},
+		{"soon", 0, false, false},
+		{time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), 90 * time.Second, true, true},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true, false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Retry-After", tt.header)
+		}
+		got, ok := retryAfter(resp)
+		if ok != tt.ok || (!tt.inexact && got != tt.want) || (tt.inexact && (got > tt.want || got < tt.want-2*time.Second)) {
+			t.Errorf("retryAfter(%q) = %s, %v, want %s, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestShouldRetryNetworkErrors(t *testing.T) {
+	for method, want := range map[string]bool{"GET": true, "DELETE": true, "POST": false, "PATCH": false} {
+		if got, _ := shouldRetry(method, nil, errors.New("connection reset")); got != want {
+			t.Errorf("shouldRetry(%s, network error) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestRetryClientDo(t *testing.T) {
+	tests := []struct {
+		method       string
+		status       int
+		retryAfter   string
+		failures     int // Failed attempts before the server succeeds, -1 for never
+		wantAttempts int32
+		wantStatus   int
+	}{
+		{"GET", 500, "0", -1, 3, 500},
+		{"GET", 503, "0", 1, 2, 200},
+		{"GET", 404, "0", -1, 1, 404},
+		{"DELETE", 502, "0", -1, 3, 502},
+		{"POST", 500, "0", -1, 1, 500},
+		{"POST", 429, "0", 2, 3, 200},
+		{"POST", 503, "0", -1, 3, 503},
+		{"PATCH", 504, "0", -1, 1, 504},
+		{"PATCH", 429, "0", 1, 2, 200},
+		{"GET", 429, "600", -1, 1, 429}, // Retry-After beyond maxRetryAfter is left to the caller
+	}
+	for _, tt := range tests {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := attempts.Add(1)
+			if body, _ := io.ReadAll(r.Body); r.Method != "GET" && r.Method != "DELETE" && string(body) != `{"a":1}` {
+				t.Errorf("%s attempt %d sent body %q", r.Method, n, body)
+			}
+			if tt.failures >= 0 && int(n) > tt.failures {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Header().Set("Retry-After", tt.retryAfter)
+			w.WriteHeader(tt.status)
+		}))
+
+		var body io.Reader
+		if tt.method != "GET" && tt.method != "DELETE" {
+			body = strings.NewReader(`{"a":1}`)
+		}
+		req, _ := http.NewRequest(tt.method, server.URL, body)
+		client := &RetryClient{Name: "test", Client: server.Client(), MaxRetries: 2}
+		resp, err := client.Do(req)
+		server.Close()
+		if err != nil {
+			t.Errorf("%s %d: %v", tt.method, tt.status, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus || attempts.Load() != tt.wantAttempts {
+			t.Errorf("%s %d: status %d after %d attempts, want %d after %d", tt.method, tt.status, resp.StatusCode, attempts.Load(), tt.wantStatus, tt.wantAttempts)
+		}
+	}
+}
+
+func TestRetryClientGetCounted(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	client := &RetryClient{Name: "test", Client: server.Client(), MaxRetries: 5}
+
+	var asked []bool
+	resp, err := client.GetCounted(server.URL, func(retry bool) bool {
+		asked = append(asked, retry)
+		return len(asked) <= 2 // The first attempt and one retry
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if attempts.Load() != 2 || len(asked) != 3 || asked[0] || !asked[1] {
+		t.Errorf("%d attempts, allow asked %v; want 2 attempts after asking [false true true]", attempts.Load(), asked)
+	}
+
+	if _, err := client.GetCounted(server.URL, func(bool) bool { return false }); err == nil || attempts.Load() != 2 {
+		t.Errorf("request sent although refused: err = %v, %d attempts", err, attempts.Load())
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// InvertAmounts is the invert_amounts setting of an account: false, true or "auto"
+type InvertAmounts int
+
+const (
+	InvertNever  InvertAmounts = iota // Import amounts with the sign SimpleFIN reports (default)
+	InvertAlways                      // Flip the sign of every amount
+	InvertAuto                        // Flip the sign when the Sure account is a liability
+)
+
+// UnmarshalJSON accepts true, false or "auto"
+func (i *InvertAmounts) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*i = InvertNever
+		if b {
+			*i = InvertAlways
+		}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil && strings.EqualFold(s, "auto") {
+		*i = InvertAuto
+		return nil
+	}
+	return fmt.Errorf(`invert_amounts must be true, false or "auto", got %s`, data)
+}
+
+// MarshalJSON writes the setting back as it is written in config.json
+func (i InvertAmounts) MarshalJSON() ([]byte, error) {
+	if i == InvertAuto {
+		return []byte(`"auto"`), nil
+	}
+	return json.Marshal(i == InvertAlways)
+}
+
+// resolveAmountSigns decides for every mapped account whether its amounts are
+// inverted, looking up the classification of the Sure account for "auto". "auto"
+// accounts missing from Sure are returned as errors so that callers do not import
+// them with a guessed sign.
+func resolveAmountSigns(config Config, sureAccounts []SureAccount) error {
+	classifications := make(map[string]string)
+	for _, acc := range sureAccounts {
+		classifications[acc.ID] = acc.Classification
+	}
+
+	var errs []error
+	for id, accConfig := range config.AccountMap {
+		classification, found := classifications[accConfig.SureID]
+		accConfig.liability = classification == "liability"
+		switch accConfig.InvertAmounts {
+		case InvertAlways:
+			accConfig.invert = true
+		case InvertAuto:
+			if !found && !accConfig.Closed {
+				errs = append(errs, fmt.Errorf("invert_amounts of %s is auto but Sure account %s was not found", accConfig.Name, accConfig.SureID))
+			}
+			accConfig.invert = accConfig.liability
+		}
+		config.AccountMap[id] = accConfig
+	}
+	return errors.Join(errs...)
+}
+
+// sureAmount returns a transaction amount with the sign the account imports it with
+func (a AccountConfig) sureAmount(amount string) string {
+	if a.invert {
+		return negateAmount(amount)
+	}
+	return amount
+}
+
+// sureBalance returns a balance with the sign Sure expects. Sure keeps liability
+// balances as the positive amount owed, so a negative liability balance is flipped
+// whatever invert_amounts says; banks that already report the amount owed as a
+// positive balance are left alone.
+func (a AccountConfig) sureBalance(balance string) string {
+	if !a.liability {
+		return balance
+	}
+	if value, err := parseAmount(balance); err == nil && value < 0 {
+		return negateAmount(balance)
+	}
+	return balance
+}
+
+// negateAmount flips the sign of a decimal amount, keeping its formatting. Zero and
+// amounts that do not parse are returned unchanged.
+func negateAmount(amount string) string {
+	amount = strings.TrimSpace(amount)
+	if value, err := parseAmount(amount); err != nil || value == 0 {
+		return amount
+	}
+	if positive, ok := strings.CutPrefix(amount, "-"); ok {
+		return positive
+	}
+	return "-" + strings.TrimPrefix(amount, "+")
+}
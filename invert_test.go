@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestInvertAmountsJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want InvertAmounts
+		out  string
+	}{
+		{`false`, InvertNever, `false`},
+		{`true`, InvertAlways, `true`},
+		{`"auto"`, InvertAuto, `"auto"`},
+		{`"AUTO"`, InvertAuto, `"auto"`},
+	}
+	for _, tt := range tests {
+		var got InvertAmounts
+		if err := json.Unmarshal([]byte(tt.in), &got); err != nil {
+			t.Errorf("Unmarshal(%s): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, want %v", tt.in, got, tt.want)
+		}
+		out, err := json.Marshal(got)
+		if err != nil || string(out) != tt.out {
+			t.Errorf("Marshal(%v) = %s, %v, want %s", got, out, err, tt.out)
+		}
+	}
+
+	for _, in := range []string{`"yes"`, `1`, `null`} {
+		var got InvertAmounts
+		if err := json.Unmarshal([]byte(in), &got); err == nil && in != `null` {
+			t.Errorf("Unmarshal(%s) accepted an invalid value", in)
+		}
+	}
+
+	// Left out of config.json when false
+	data, _ := json.Marshal(AccountConfig{SureID: "s", Name: "n"})
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+	if _, ok := fields["invert_amounts"]; ok {
+		t.Errorf("default invert_amounts written to config: %s", data)
+	}
+}
+
+func TestNegateAmount(t *testing.T) {
+	tests := map[string]string{
+		"-12.50": "12.50",
+		"12.50":  "-12.50",
+		"+3":     "-3",
+		"0.00":   "0.00",
+		"-0.00":  "-0.00",
+		"n/a":    "n/a",
+		" 7.10 ": "-7.10",
+	}
+	for in, want := range tests {
+		if got := negateAmount(in); got != want {
+			t.Errorf("negateAmount(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestResolveAmountSigns(t *testing.T) {
+	config := Config{AccountMap: map[string]AccountConfig{
+		"card":   {SureID: "s-card", Name: "Card", InvertAmounts: InvertAuto},
+		"chk":    {SureID: "s-chk", Name: "Checking", InvertAmounts: InvertAuto},
+		"loan":   {SureID: "s-loan", Name: "Loan"},
+		"odd":    {SureID: "s-odd", Name: "Odd", InvertAmounts: InvertAlways},
+		"amex":   {SureID: "s-amex", Name: "Amex", InvertAmounts: InvertAlways},
+		"store":  {SureID: "s-store", Name: "Store Card", BalanceOnly: true},
+		"gone":   {SureID: "s-gone", Name: "Gone", InvertAmounts: InvertAuto},
+		"closed": {SureID: "s-closed", Name: "Closed", InvertAmounts: InvertAuto, Closed: true},
+	}}
+	sureAccounts := []SureAccount{
+		{ID: "s-card", Classification: "liability"},
+		{ID: "s-chk", Classification: "asset"},
+		{ID: "s-loan", Classification: "liability"},
+		{ID: "s-odd", Classification: "asset"},
+		{ID: "s-amex", Classification: "liability"},
+		{ID: "s-store", Classification: "liability"},
+	}
+
+	if err := resolveAmountSigns(config, sureAccounts); err == nil {
+		t.Error("no error for an auto account missing from Sure")
+	}
+
+	tests := []struct {
+		id               string
+		amount, balance  string
+		wantAmount, want string
+	}{
+		{"card", "-20.00", "-500.00", "20.00", "500.00"},
+		{"card", "-20.00", "0.00", "20.00", "0.00"},
+		{"chk", "-20.00", "1000.00", "-20.00", "1000.00"},
+		{"chk", "-20.00", "-15.00", "-20.00", "-15.00"},     // Overdrawn asset
+		{"loan", "-20.00", "-9000.00", "-20.00", "9000.00"}, // Amounts not inverted, balance still owed
+		{"odd", "-20.00", "1000.00", "20.00", "1000.00"},    // Asset balances are never inverted
+		{"amex", "-20.00", "500.00", "20.00", "500.00"},     // Bank already reports the amount owed
+		{"store", "", "-75.00", "", "75.00"},                // Balance-only card without invert_amounts
+		{"gone", "-20.00", "-5.00", "-20.00", "-5.00"},
+	}
+	for _, tt := range tests {
+		accConfig := config.AccountMap[tt.id]
+		if got := accConfig.sureAmount(tt.amount); got != tt.wantAmount {
+			t.Errorf("%s: sureAmount(%s) = %s, want %s", tt.id, tt.amount, got, tt.wantAmount)
+		}
+		if got := accConfig.sureBalance(tt.balance); got != tt.want {
+			t.Errorf("%s: sureBalance(%s) = %s, want %s", tt.id, tt.balance, got, tt.want)
+		}
+	}
+}
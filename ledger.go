@@ -0,0 +1,400 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const ledgerFile = "ledger.db"
+
+var errLedgerReadOnly = errors.New("ledger is open read-only")
+
+var (
+	ledgerBucket = []byte("transactions")
+	// Creations sent to Sure whose outcome is not yet recorded, see Ledger.RecordIntents
+	intentsBucket = []byte("intents")
+)
+
+// LedgerEntry records a SimpleFIN transaction that has been imported into Sure
+type LedgerEntry struct {
+	AccountID         string    `json:"account_id"`                    // SimpleFIN account ID
+	TransactionID     string    `json:"transaction_id"`                // SimpleFIN transaction ID
+	SureTransactionID string    `json:"sure_transaction_id,omitempty"` // ID of the transaction created in Sure
+	SureAccountID     string    `json:"sure_account_id,omitempty"`
+	Amount            string    `json:"amount"` // As reported by SimpleFIN, before invert_amounts
+	Date              string    `json:"date"`
+	Posted            int64     `json:"posted,omitempty"`        // SimpleFIN timestamps the date is taken from
+	TransactedAt      int64     `json:"transacted_at,omitempty"` // Both zero for entries recorded before they were kept
+	DescriptionHash   string    `json:"description_hash"`
+	ImportedAt        time.Time `json:"imported_at"`
+	UpdatedAt         time.Time `json:"updated_at,omitzero"` // Last time a bank revision was pushed to Sure
+	Pending           bool      `json:"pending,omitempty"`
+	ReplacedPendingID string    `json:"replaced_pending_id,omitempty"` // Pending transaction this posted one was matched to
+	Migrated          bool      `json:"migrated,omitempty"`            // Carried over from sync_state.json, details may be missing
+	TransferID        string    `json:"transfer_id,omitempty"`         // Sure transfer this transaction is a side of
+}
+
+// Changes describes how a re-fetched transaction differs from this entry. The
+// SimpleFIN amount, posted and transacted timestamps, description hash and pending
+// status together act as the transaction's fingerprint, so changing invert_amounts
+// or date_source is not mistaken for a revision. Entries recorded without the
+// timestamps compare their date with the transacted date they were imported with;
+// entries migrated without details never report changes.
+func (e LedgerEntry) Changes(tx SFTransaction) []string {
+	if e.Amount == "" && e.Date == "" && e.DescriptionHash == "" {
+		return nil
+	}
+
+	var changes []string
+	if e.Amount != tx.Amount {
+		changes = append(changes, fmt.Sprintf("amount %s -> %s", e.Amount, tx.Amount))
+	}
+	if e.Posted == 0 && e.TransactedAt == 0 {
+		if date := formatUnixDate(transactionTimestamp(tx, DateSourcePostedFallback)); e.Date != date {
+			changes = append(changes, fmt.Sprintf("date %s -> %s", e.Date, date))
+		}
+	} else {
+		if e.Posted != tx.Posted {
+			changes = append(changes, fmt.Sprintf("posted %s -> %s", formatUnixDate(e.Posted), formatUnixDate(tx.Posted)))
+		}
+		if e.TransactedAt != tx.TransactedAt {
+			changes = append(changes, fmt.Sprintf("transacted %s -> %s", formatUnixDate(e.TransactedAt), formatUnixDate(tx.TransactedAt)))
+		}
+	}
+	if e.DescriptionHash != hashDescription(tx.Description) {
+		changes = append(changes, fmt.Sprintf("description -> %q", tx.Description))
+	}
+	if e.Pending && !tx.Pending {
+		changes = append(changes, "pending -> posted")
+	}
+	return changes
+}
+
+// Ledger is the durable record of imported transactions, keyed by SimpleFIN transaction ID
+type Ledger struct {
+	db       *bolt.DB
+	readOnly bool // Opened for a dry run, writes are refused
+}
+
+// OpenLedgerReadOnly opens the ledger for reading only, for a dry run. A missing
+// ledger is treated as empty and the legacy sync_state.json is not migrated.
+func OpenLedgerReadOnly(path string) (*Ledger, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// bbolt cannot open a missing file read-only, so stand in an empty temporary ledger
+		tmp, err := os.CreateTemp("", "ledger-*.db")
+		if err != nil {
+			return nil, err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name()) // The open database keeps the file alive until Close
+		ledger, err := openLedgerDB(tmp.Name())
+		if err != nil {
+			return nil, err
+		}
+		ledger.readOnly = true
+		return ledger, nil
+	}
+	if _, err := os.Stat(stateFile); err == nil {
+		log.Printf("Warning: %s has not been migrated into the ledger yet, previously imported transactions may be listed as new", stateFile)
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("open ledger %s: %w", path, err)
+	}
+	return &Ledger{db: db, readOnly: true}, nil
+}
+
+// OpenLedger opens (or creates) the ledger database, migrating the legacy
+// sync_state.json the first time it is opened
+func OpenLedger(path string) (*Ledger, error) {
+	ledger, err := openLedgerDB(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := ledger.migrateLegacyState(); err != nil {
+		ledger.Close()
+		return nil, fmt.Errorf("migrate %s: %w", stateFile, err)
+	}
+	return ledger, nil
+}
+
+// openLedgerDB opens the database and creates the buckets
+func openLedgerDB(path string) (*Ledger, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open ledger %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(ledgerBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(intentsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Ledger{db: db}, nil
+}
+
+// Close closes the underlying database
+func (l *Ledger) Close() error {
+	return l.db.Close()
+}
+
+// Get returns the ledger entry for a SimpleFIN transaction ID
+func (l *Ledger) Get(transactionID string) (LedgerEntry, bool, error) {
+	var entry LedgerEntry
+	found := false
+	err := l.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(ledgerBucket).Get([]byte(transactionID))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &entry)
+	})
+	return entry, found, err
+}
+
+// PendingEntries returns the pending transactions imported for a SimpleFIN account
+func (l *Ledger) PendingEntries(accountID string) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	err := l.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ledgerBucket).ForEach(func(_, data []byte) error {
+			var entry LedgerEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			if entry.Pending && entry.AccountID == accountID {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// EntriesSince returns the recorded transactions dated on or after the given YYYY-MM-DD day
+func (l *Ledger) EntriesSince(date string) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	err := l.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ledgerBucket).ForEach(func(_, data []byte) error {
+			var entry LedgerEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			if entry.Date >= date {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// LedgerBatch is a set of ledger changes committed in one database transaction
+type LedgerBatch struct {
+	Put          []LedgerEntry // Entries to store or replace
+	Delete       []string      // Transaction IDs to remove
+	ClearIntents []string      // Transaction IDs whose creation outcome is now known
+}
+
+// Commit applies a batch atomically
+func (l *Ledger) Commit(batch LedgerBatch) error {
+	if l.readOnly {
+		return errLedgerReadOnly
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ledgerBucket)
+		for _, id := range batch.Delete {
+			if err := bucket.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		for _, entry := range batch.Put {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(entry.TransactionID), data); err != nil {
+				return err
+			}
+		}
+		intents := tx.Bucket(intentsBucket)
+		for _, id := range batch.ClearIntents {
+			if err := intents.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RecordIntents journals transactions that are about to be created in Sure. An
+// intent left behind by a crash or an ambiguous failure means the transaction may
+// or may not exist in Sure; RecoverIntents resolves it before it is created again.
+func (l *Ledger) RecordIntents(entries []LedgerEntry) error {
+	if l.readOnly {
+		return errLedgerReadOnly
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		intents := tx.Bucket(intentsBucket)
+		for _, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := intents.Put([]byte(entry.TransactionID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// HasIntent reports whether a creation of the transaction is still unresolved
+func (l *Ledger) HasIntent(transactionID string) bool {
+	found := false
+	l.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(intentsBucket).Get([]byte(transactionID)) != nil
+		return nil
+	})
+	return found
+}
+
+// Intents returns the unresolved creation intents
+func (l *Ledger) Intents() ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	err := l.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(intentsBucket).ForEach(func(_, data []byte) error {
+			var entry LedgerEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// Count returns the number of recorded transactions
+func (l *Ledger) Count() int {
+	count := 0
+	l.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(ledgerBucket).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+// AccountCounts returns the number of recorded transactions per SimpleFIN account
+func (l *Ledger) AccountCounts() (map[string]int, error) {
+	counts := make(map[string]int)
+	err := l.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ledgerBucket).ForEach(func(_, data []byte) error {
+			var entry LedgerEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			counts[entry.AccountID]++
+			return nil
+		})
+	})
+	return counts, err
+}
+
+// migrateLegacyState imports the transaction IDs from sync_state.json into an
+// empty ledger. Details are filled in from the tmp/ account cache where available.
+// The legacy file is renamed afterwards so the migration only runs once.
+func (l *Ledger) migrateLegacyState() error {
+	if _, err := os.Stat(stateFile); err != nil {
+		return nil
+	}
+	if l.Count() > 0 {
+		return nil
+	}
+
+	legacy := LoadState()
+	cached := loadCachedTransactions()
+	migratedAt := time.Now()
+
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ledgerBucket)
+		for txID := range legacy {
+			entry := LedgerEntry{
+				TransactionID: txID,
+				ImportedAt:    migratedAt,
+				Migrated:      true,
+			}
+			if c, ok := cached[txID]; ok {
+				entry.AccountID = c.accountID
+				entry.Amount = c.tx.Amount
+				entry.Date = formatUnixDate(c.tx.TransactedAt)
+				entry.Posted = c.tx.Posted
+				entry.TransactedAt = c.tx.TransactedAt
+				entry.DescriptionHash = hashDescription(c.tx.Description)
+			}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(txID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Migrated %d transactions from %s into %s", len(legacy), stateFile, ledgerFile)
+	return os.Rename(stateFile, stateFile+".migrated")
+}
+
+// cachedTransaction is a transaction found in the tmp/ account cache
+type cachedTransaction struct {
+	accountID string
+	tx        SFTransaction
+}
+
+// loadCachedTransactions indexes every transaction in the tmp/ account cache by ID
+func loadCachedTransactions() map[string]cachedTransaction {
+	result := make(map[string]cachedTransaction)
+	files, _ := filepath.Glob(filepath.Join(cacheDir, "account_*.json"))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var cached CachedAccount
+		if err := json.Unmarshal(data, &cached); err != nil {
+			continue
+		}
+		for _, tx := range cached.Account.Transactions {
+			result[tx.ID] = cachedTransaction{accountID: cached.Account.ID, tx: tx}
+		}
+	}
+	return result
+}
+
+// hashDescription returns a stable fingerprint of a transaction description
+func hashDescription(description string) string {
+	sum := sha256.Sum256([]byte(description))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLedgerEntryChanges(t *testing.T) {
+	const day = 24 * 60 * 60
+	posted, transacted := int64(1_717_243_200), int64(1_717_243_200-2*day)
+	tx := SFTransaction{ID: "T-1", Amount: "-5.00", Description: "COFFEE", Posted: posted, TransactedAt: transacted}
+	entry := LedgerEntry{TransactionID: "T-1", Amount: "-5.00", Date: formatUnixDate(posted), Posted: posted, TransactedAt: transacted,
+		DescriptionHash: hashDescription("COFFEE")}
+
+	tests := []struct {
+		name  string
+		entry func(LedgerEntry) LedgerEntry
+		tx    func(SFTransaction) SFTransaction
+		want  []string
+	}{
+		{"unchanged", nil, nil, nil},
+		{"imported with another date source", func(e LedgerEntry) LedgerEntry { e.Date = formatUnixDate(transacted); return e }, nil, nil},
+		{"amount revised", nil, func(tx SFTransaction) SFTransaction { tx.Amount = "-5.50"; return tx }, []string{"amount -5.00 -> -5.50"}},
+		{"posted revised", nil, func(tx SFTransaction) SFTransaction { tx.Posted += day; return tx },
+			[]string{"posted " + formatUnixDate(posted) + " -> " + formatUnixDate(posted+day)}},
+		{"description revised", nil, func(tx SFTransaction) SFTransaction { tx.Description = "COFFEE SHOP"; return tx },
+			[]string{`description -> "COFFEE SHOP"`}},
+		{"pending posts", func(e LedgerEntry) LedgerEntry { e.Pending = true; return e }, nil, []string{"pending -> posted"}},
+		{"recorded without timestamps", func(e LedgerEntry) LedgerEntry {
+			e.Posted, e.TransactedAt, e.Date = 0, 0, formatUnixDate(transacted)
+			return e
+		}, nil, nil},
+		{"recorded without timestamps, revised", func(e LedgerEntry) LedgerEntry {
+			e.Posted, e.TransactedAt, e.Date = 0, 0, formatUnixDate(transacted-day)
+			return e
+		}, nil, []string{"date " + formatUnixDate(transacted-day) + " -> " + formatUnixDate(transacted)}},
+		{"migrated without details", func(LedgerEntry) LedgerEntry { return LedgerEntry{TransactionID: "T-1", Migrated: true} }, nil, nil},
+	}
+	for _, tt := range tests {
+		e, x := entry, tx
+		if tt.entry != nil {
+			e = tt.entry(e)
+		}
+		if tt.tx != nil {
+			x = tt.tx(x)
+		}
+		if got := e.Changes(x); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Changes = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
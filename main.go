@@ -1,46 +1,129 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"time"
+	"os"
+	"slices"
+	"strings"
 )
 
+// Exit codes
+const (
+	exitDriftExceeded  = 2 // Reconciliation found drift on an account with drift_action "fail"
+	exitPartialFailure = 3 // Transactions for one or more accounts could not be fetched
+)
+
+const usage = `Usage: sure-simplefin-sync [command] [flags]
+
+Commands:
+  sync              Import transactions and balances from SimpleFIN into Sure (default)
+  accounts list     List Sure and SimpleFIN accounts and how they are mapped
+  accounts map      Map a SimpleFIN account to a Sure account
+  accounts unmap    Remove the mapping of a SimpleFIN account
+  status            Show sync dates, ledger size and SimpleFIN request usage
+  metadata sync     Update mapped account names from Sure
+  claim             Exchange a SimpleFIN setup token for an access URL
+  backfill          Import an account's history over a date range
+  rules test        Show which rule matches a cached transaction
+
+Run "sure-simplefin-sync <command> -h" for the flags of a command.
+`
+
 func main() {
-	autoCreate := flag.Bool("auto-create-accounts", false, "Automatically prompt to create unmapped accounts")
-	forceRefresh := flag.Bool("force-refresh", false, "Force refresh SimpleFIN data (ignore cache)")
-	syncMetadata := flag.Bool("sync-metadata", false, "Sync account names from Sure and update config")
-	flag.Parse()
+	args := os.Args[1:]
+	command := "sync"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-	config := LoadConfig()
+	switch command {
+	case "sync":
+		runSync(args)
+	case "accounts":
+		runAccounts(args)
+	case "status":
+		runStatus(args)
+	case "metadata":
+		runMetadata(args)
+	case "claim":
+		runClaim(args)
+	case "backfill":
+		runBackfill(args)
+	case "rules":
+		runRules(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(1)
+	}
+}
+
+// runSync imports transactions and balances from SimpleFIN into Sure
+func runSync(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	autoCreate := fs.Bool("auto-create-accounts", false, "Automatically prompt to create unmapped accounts")
+	forceRefresh := fs.Bool("force-refresh", false, "Force refresh SimpleFIN data (ignore cache)")
+	offline := fs.Bool("offline", false, "Sync purely from cached SimpleFIN data without contacting SimpleFIN")
+	dryRunFlag := fs.Bool("dry-run", false, "Fetch and plan the sync, print what would be sent to Sure and write nothing")
+	output := fs.String("output", DryRunOutputTable, "Dry-run output format: table or json")
+	syncMetadata := fs.Bool("sync-metadata", false, `Deprecated, use "metadata sync"`)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage+"\nFlags of sync:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
 
 	if *syncMetadata {
-		syncAccountMetadata(&config)
-		if err := SaveConfig(config); err != nil {
-			log.Fatalf("Failed to save config: %v", err)
-		}
-		log.Println("Metadata sync complete.")
+		runMetadata([]string{"sync"})
 		return
 	}
 
-	// Print Sure Accounts
-	log.Println("Fetching accounts from Sure...")
+	if *output != DryRunOutputTable && *output != DryRunOutputJSON {
+		log.Fatalf("Unknown --output %q, expected table or json", *output)
+	}
+	if *dryRunFlag {
+		// Sure writes are recorded instead of sent, and no state or config file is written
+		dryRun = &DryRunReport{}
+		log.Println("Dry run: nothing will be written to Sure or to local state.")
+	}
+
+	config := LoadConfig()
+	ConfigureHTTPClients(config)
+	// rules.json is parsed before anything is fetched, so a mistake in it costs no SimpleFIN requests
+	rules := loadRules(config)
+
+	sureAccountsMap := make(map[string]SureAccount)
 	sureAccounts, err := FetchSureAccounts(config.SureBaseURL, config.SureAPIKey)
 	if err != nil {
 		log.Printf("Failed to fetch Sure accounts: %v", err)
-	} else {
-		fmt.Println("\nSure Accounts:")
-		for _, acc := range sureAccounts {
-			fmt.Printf("- %s (ID: %s) Balance: %s\n", acc.Name, acc.ID, acc.Balance)
-		}
-		fmt.Println()
+	}
+	for _, acc := range sureAccounts {
+		sureAccountsMap[acc.ID] = acc
+	}
+	if err := resolveAmountSigns(config, sureAccounts); err != nil {
+		log.Fatalf("Cannot determine amount signs: %v", err)
 	}
 
-	state := LoadState()
+	openLedger := OpenLedger
+	if dryRun != nil {
+		openLedger = OpenLedgerReadOnly
+	}
+	ledger, err := openLedger(ledgerFile)
+	if err != nil {
+		log.Fatalf("Failed to open transaction ledger: %v", err)
+	}
+	defer ledger.Close()
 
 	// 1. Handle SimpleFIN Authentication
-	if config.AccessURL == "" && config.SetupToken != "" {
+	if *offline {
+		log.Println("Offline mode: using cached SimpleFIN data only.")
+	} else if config.AccessURL == "" && dryRun != nil {
+		log.Fatal(`No AccessURL in config.json, run "claim" before a dry run`)
+	} else if config.AccessURL == "" && config.SetupToken != "" {
 		config.AccessURL = ClaimSimpleFINToken(config.SetupToken)
 		if err := SaveConfig(config); err != nil {
 			log.Fatalf("Failed to save config: %v", err)
@@ -52,16 +135,33 @@ func main() {
 
 	// 2. Fetch Data from SimpleFIN
 	log.Println("Fetching transactions from SimpleFIN...")
-	sfData := FetchSimpleFINData(config.AccessURL, *forceRefresh, config)
+	accountSyncState := LoadAccountSyncState()
+	sfData, fetchErrors, err := FetchSimpleFINData(config.AccessURL, FetchOptions{ForceRefresh: *forceRefresh, Offline: *offline, AutoCreate: *autoCreate}, config, ledger, accountSyncState)
+	if err != nil {
+		log.Fatalf("Failed to fetch SimpleFIN data: %v", err)
+	}
+	failedAccounts := make(map[string]bool)
+	for _, fetchErr := range fetchErrors {
+		failedAccounts[fetchErr.AccountID] = true
+	}
+
+	// 3. Process and Sync to Sure, first resolving imports interrupted by an earlier run
+	if dryRun == nil {
+		RecoverIntents(config, ledger)
+	}
+
+	transferred := SyncTransfers(config, ledger, sfData.Accounts, failedAccounts)
 
-	// 3. Process and Sync to Sure
 	newTxCount := 0
+	updatedTxCount := 0
+	deletedTxCount := 0
+	balanceCount := 0
 	for _, account := range sfData.Accounts {
 		accConfig, mapped := config.AccountMap[account.ID]
 		if !mapped {
 			if *autoCreate {
 				var err error
-				sureAccountID, err := PromptAndCreateSureAccount(config.SureBaseURL, config.SureAPIKey, account)
+				sureAccountID, accountableType, err := PromptAndCreateSureAccount(config.SureBaseURL, config.SureAPIKey, account)
 				if err != nil {
 					log.Printf("Failed to create account for %s: %v", account.Name, err)
 					log.Fatalf("Please manually create the account in Sure and try again. ID: %s", account.ID)
@@ -71,91 +171,111 @@ func main() {
 					SureID: sureAccountID,
 					Name:   account.Name,
 				}
+				if accountableType == "Investment" {
+					accConfig.Holdings = HoldingsModeValuation
+				}
 				config.AccountMap[account.ID] = accConfig
-				if err := SaveConfig(config); err != nil {
-					log.Fatalf("Failed to save config: %v", err)
+				if dryRun == nil {
+					if err := SaveConfig(config); err != nil {
+						log.Fatalf("Failed to save config: %v", err)
+					}
 				}
 				log.Printf("Successfully mapped SimpleFIN account %s to Sure account %s", account.Name, sureAccountID)
 			} else {
 				log.Printf("Skipping SimpleFIN account %s, %s (Not mapped in config): %s", account.ID, account.Name, account.Org.Domain)
+				advanceSyncDate(accountSyncState, account)
 				continue
 			}
 		}
 
-		if accConfig.BalanceOnly {
-			log.Printf("Skipping transactions for %s (balance_only is set)", accConfig.Name)
+		if accConfig.Closed {
+			log.Printf("Skipping closed account %s", accConfig.Name)
 			continue
 		}
 
-		sureAccountID := accConfig.SureID
-
-		for _, tx := range account.Transactions {
-			if _, processed := state[tx.ID]; processed {
-				continue // Idempotency check: skip if already processed
-			}
+		if sureAcc, ok := sureAccountsMap[accConfig.SureID]; ok {
+			checkAccountCurrency(account, sureAcc)
+		}
 
-			// Format for Sure API
-			txDate := time.Unix(tx.TransactedAt, 0).Format("2006-01-02")
-			txName := tx.Description
-			if txName == "" {
-				txName = txDate
-			}
-			payload := SureTransaction{
-				AccountID: sureAccountID,
-				Amount:    tx.Amount,
-				Date:      txDate,
-				Name:      txName,
-				Notes:     fmt.Sprintf("Imported via SimpleFIN. ID: %s", tx.ID),
+		if accConfig.Holdings != "" && failedAccounts[account.ID] {
+			log.Printf("Skipping holdings for %s (not fetched this run)", accConfig.Name)
+		} else if accConfig.Holdings != "" {
+			if err := SyncAccountHoldings(config, accConfig, account, accountSyncState); err != nil {
+				log.Printf("Failed to sync holdings for %s: %v", accConfig.Name, err)
 			}
+		}
 
-			err := CreateSureTransaction(config.SureBaseURL, config.SureAPIKey, payload)
+		if accConfig.BalanceOnly {
+			pushed, err := SyncAccountBalance(config, accConfig, account, accountSyncState)
 			if err != nil {
-				log.Printf("Failed to create tx %s: %v", tx.ID, err)
-				continue
+				log.Printf("Failed to push balance for %s: %v", accConfig.Name, err)
+			} else if pushed {
+				balanceCount++
+			} else {
+				log.Printf("Balance unchanged for %s, skipping", accConfig.Name)
 			}
+			continue
+		}
 
-			// Mark as processed and save state immediately
-			state[tx.ID] = true
-			if err := SaveState(state); err != nil {
-				log.Printf("Warning: Failed to save state: %v", err)
-			}
-			newTxCount++
-			log.Printf("Synced transaction: %s - %s", txDate, txName)
+		if failedAccounts[account.ID] {
+			log.Printf("Skipping transactions for %s (not fetched this run)", accConfig.Name)
+			continue
+		}
+
+		account.Transactions = slices.DeleteFunc(slices.Clone(account.Transactions), func(tx SFTransaction) bool {
+			return transferred[tx.ID] // Already imported as one side of a transfer
+		})
+		result := SyncAccountTransactions(config, ledger, rules, account, accConfig)
+		newTxCount += result.Created
+		updatedTxCount += result.Updated
+		deletedTxCount += result.Deleted
+		if result.Failed == 0 && result.Deferred == 0 {
+			advanceSyncDate(accountSyncState, account)
+		} else {
+			log.Printf("%sNot all transactions for %s were imported, they will be retried next run%s", colorRed, accConfig.Name, colorReset)
 		}
 	}
 
-	log.Printf("Sync complete. %d new transactions added.", newTxCount)
-}
+	// 4. Compare balances between SimpleFIN and Sure
+	driftFailed := ReconcileBalances(config, sfData.Accounts, accountSyncState)
 
-func syncAccountMetadata(config *Config) {
-	log.Println("Syncing account metadata from Sure...")
-	sureAccounts, err := FetchSureAccounts(config.SureBaseURL, config.SureAPIKey)
-	if err != nil {
-		log.Fatalf("Failed to fetch Sure accounts: %v", err)
+	if dryRun != nil {
+		if err := dryRun.Print(os.Stdout, *output, config); err != nil {
+			log.Printf("Failed to print dry-run report: %v", err)
+		}
+	} else if err := SaveAccountSyncState(accountSyncState); err != nil {
+		log.Printf("Warning: Failed to save account sync state: %v", err)
 	}
 
-	sureAccountsMap := make(map[string]SureAccount)
-	for _, acc := range sureAccounts {
-		sureAccountsMap[acc.ID] = acc
+	if dryRun != nil {
+		log.Printf("Dry run complete. %d transactions would be added, %d updated, %d removed, %d balances updated.", newTxCount, updatedTxCount, deletedTxCount, balanceCount)
+	} else {
+		log.Printf("Sync complete. %d new transactions added, %d updated, %d removed, %d balances updated.", newTxCount, updatedTxCount, deletedTxCount, balanceCount)
 	}
-
-	updated := false
-	for sfID, accConfig := range config.AccountMap {
-		if sureAcc, ok := sureAccountsMap[accConfig.SureID]; ok {
-			if accConfig.Name != sureAcc.Name {
-				log.Printf("Updating name for account %s: %s -> %s", sfID, accConfig.Name, sureAcc.Name)
-				accConfig.Name = sureAcc.Name
-				config.AccountMap[sfID] = accConfig
-				updated = true
+	if len(fetchErrors) > 0 {
+		log.Printf("%sTransactions for %d account(s) were not fetched, they will be retried next run:%s", colorRed, len(fetchErrors), colorReset)
+		partialFailure := false
+		for _, fetchErr := range fetchErrors {
+			log.Printf("  - %v", fetchErr)
+			if !errors.Is(fetchErr.Err, ErrDeferred) {
+				partialFailure = true
 			}
-		} else {
-			log.Printf("Warning: Mapped Sure account %s not found in Sure", accConfig.SureID)
 		}
+		if partialFailure {
+			os.Exit(exitPartialFailure)
+		}
+	}
+	if driftFailed {
+		log.Println("Balance drift exceeded threshold on one or more accounts.")
+		os.Exit(exitDriftExceeded)
 	}
+}
 
-	if updated {
-		log.Println("Metadata updated.")
-	} else {
-		log.Println("No metadata changes detected.")
+// checkAccountCurrency warns when a SimpleFIN account and its mapped Sure account use different currencies
+func checkAccountCurrency(account SFAccount, sureAcc SureAccount) {
+	currency := ResolveCurrency(account.Currency)
+	if sureAcc.Currency != "" && !strings.EqualFold(currency, sureAcc.Currency) {
+		log.Printf("%sWarning: SimpleFIN account %s is in %s but Sure account %s is in %s%s",
+			colorRed, account.Name, currency, sureAcc.Name, sureAcc.Currency, colorReset)
 	}
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Normalization presets, applied in this order
+const (
+	NormalizeProcessorPrefixes = "processor-prefixes" // POS DEBIT, CHECKCARD 0412, SQ *, TST*, PAYPAL *, ...
+	NormalizeStoreNumbers      = "store-numbers"      // #1234, T-1234, runs of 3+ digits and *2K4 style reference codes
+	NormalizeLocations         = "locations"          // Trailing "CITY ST" or "ST" after the merchant, phone numbers and web addresses
+	NormalizeCasing            = "casing"             // Title-case names that are entirely upper case
+	NormalizeAll               = "all"                // Every preset above
+)
+
+var normalizePresetOrder = []string{NormalizeProcessorPrefixes, NormalizeStoreNumbers, NormalizeLocations, NormalizeCasing}
+
+var usStateCodes = map[string]bool{
+	"AL": true, "AK": true, "AZ": true, "AR": true, "CA": true, "CO": true, "CT": true, "DE": true, "DC": true, "FL": true,
+	"GA": true, "HI": true, "ID": true, "IL": true, "IN": true, "IA": true, "KS": true, "KY": true, "LA": true, "ME": true,
+	"MD": true, "MA": true, "MI": true, "MN": true, "MS": true, "MO": true, "MT": true, "NE": true, "NV": true, "NH": true,
+	"NJ": true, "NM": true, "NY": true, "NC": true, "ND": true, "OH": true, "OK": true, "OR": true, "PA": true, "RI": true,
+	"SC": true, "SD": true, "TN": true, "TX": true, "UT": true, "VT": true, "VA": true, "WA": true, "WV": true, "WI": true,
+	"WY": true,
+}
+
+// State codes that are also common last words of merchant names, e.g. ACME CO or
+// DRIVE IN; these are only stripped when two merchant words remain
+var ambiguousStateCodes = map[string]bool{
+	"CO": true, "DE": true, "HI": true, "IN": true, "ME": true, "OK": true, "OR": true,
+}
+
+// First words of two-word city names, e.g. NEW YORK or SAN DIEGO
+var cityPrefixes = map[string]bool{
+	"NEW": true, "SAN": true, "SANTA": true, "LOS": true, "LAS": true, "EL": true, "ST": true, "ST.": true, "SAINT": true,
+	"FORT": true, "FT": true, "PORT": true, "SALT": true, "PALO": true, "LONG": true, "GRAND": true, "BATON": true,
+	"NORTH": true, "SOUTH": true, "EAST": true, "WEST": true, "LAKE": true, "CORPUS": true, "COLORADO": true,
+}
+
+var (
+	// Processor prefixes, each optionally followed by card digits or a date before the merchant
+	processorPrefixPattern = regexp.MustCompile(`(?i)^(` +
+		`(POS( DEBIT| PURCHASE| PUR)?|DBT( CRD)?|DEBIT( CARD)?( PURCHASE)?|CHECK ?CARD|PURCHASE( AUTHORIZED)?( ON)?|RECURRING( PAYMENT)?|PREAUTHORIZED( DEBIT)?|VISA DDA PUR)(\s+[\d/:]+)*\s+` +
+		`|(SQ|TST|SP|PP|PAYPAL|CKE|DD|IC)\s?\*\s*` +
+		`)+`)
+	// #1234, T-1234 or 12345, and reference codes after a "*" that contain a digit such as *2K4
+	storeNumberPattern = regexp.MustCompile(`(?i)(#\s?\d+|\*\s?[A-Z0-9]*\d[A-Z0-9]*|\b[A-Z]{0,3}-?\d{3,}\b)`)
+	starPattern        = regexp.MustCompile(`\s*\*\s*`)
+	phonePattern       = regexp.MustCompile(`\b\d{3}[-. ]\d{3}[-. ]\d{4}\b`)
+	webAddressPattern  = regexp.MustCompile(`(?i)\s[A-Z0-9-]+\.(COM|NET|ORG|CO)(/\S*)?`) // Not the leading word, which may be the merchant
+	cityWordPattern    = regexp.MustCompile(`^[A-Z][A-Z.'-]+$`)
+	whitespacePattern  = regexp.MustCompile(`\s+`)
+)
+
+// NormalizeConfig configures how payees and descriptions are cleaned up before
+// they become Sure transaction names
+type NormalizeConfig struct {
+	Presets      []string      `json:"presets,omitempty"`      // Built-in steps, see NormalizeProcessorPrefixes etc.
+	Replacements []Replacement `json:"replacements,omitempty"` // Applied after the presets, in order
+	presets      map[string]bool
+}
+
+// Replacement rewrites the parts of a name matching a case-insensitive regular
+// expression. Replace may refer to groups as $1.
+type Replacement struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+	pattern *regexp.Regexp
+}
+
+// compile validates the presets and compiles the replacement patterns
+func (n *NormalizeConfig) compile() error {
+	if len(n.Presets) > 0 {
+		n.presets = make(map[string]bool)
+	}
+	for _, preset := range n.Presets {
+		switch preset {
+		case NormalizeAll:
+			for _, p := range normalizePresetOrder {
+				n.presets[p] = true
+			}
+		case NormalizeProcessorPrefixes, NormalizeStoreNumbers, NormalizeLocations, NormalizeCasing:
+			n.presets[preset] = true
+		default:
+			return fmt.Errorf("unknown preset %q", preset)
+		}
+	}
+	for i := range n.Replacements {
+		pattern, err := regexp.Compile("(?i)" + n.Replacements[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("replacement %q: %w", n.Replacements[i].Pattern, err)
+		}
+		n.Replacements[i].pattern = pattern
+	}
+	return nil
+}
+
+// Apply normalizes a payee or description. A result that would be empty falls
+// back to the original text.
+func (n NormalizeConfig) Apply(raw string) string {
+	name := raw
+	if n.presets[NormalizeProcessorPrefixes] {
+		name = processorPrefixPattern.ReplaceAllString(name, "")
+	}
+	if n.presets[NormalizeLocations] {
+		// Before store numbers, which would break up the digits of a phone number
+		name = stripContactState(name)
+		name = phonePattern.ReplaceAllString(name, " ")
+		name = webAddressPattern.ReplaceAllString(name, " ")
+	}
+	if n.presets[NormalizeStoreNumbers] {
+		name = storeNumberPattern.ReplaceAllString(name, " ")
+		name = starPattern.ReplaceAllString(name, " ") // UBER *TRIP
+	}
+	if n.presets[NormalizeLocations] {
+		name = stripCityState(name)
+	}
+	if n.presets[NormalizeCasing] && strings.ToUpper(name) == name {
+		name = titleCase(name)
+	}
+	for _, r := range n.Replacements {
+		if r.pattern != nil {
+			name = r.pattern.ReplaceAllString(name, r.Replace)
+		}
+	}
+
+	name = strings.Trim(whitespacePattern.ReplaceAllString(name, " "), " -*#,")
+	if name == "" {
+		return strings.TrimSpace(raw)
+	}
+	return name
+}
+
+// stripContactState removes a trailing state code that follows a phone number or
+// web address standing in for the city, as in COMCAST CABLE 800-266-2278 PA
+func stripContactState(name string) string {
+	words := strings.Fields(name)
+	last := len(words) - 1
+	if last < 2 || !usStateCodes[words[last]] {
+		return name
+	}
+	if !phonePattern.MatchString(words[last-1]) && !webAddressPattern.MatchString(" "+words[last-1]) {
+		return name
+	}
+	return strings.Join(words[:last], " ")
+}
+
+// stripCityState removes a trailing upper-case "CITY ST", or the state code alone
+// when no other merchant word would remain. State codes that are also common words
+// must leave two words before the city, so that names such as ACME CO are kept.
+func stripCityState(name string) string {
+	words := strings.Fields(name)
+	last := len(words) - 1
+	if last < 1 || !usStateCodes[words[last]] {
+		return name
+	}
+	cityWords := 1
+	if last >= 3 && cityPrefixes[words[last-2]] {
+		cityWords = 2
+	}
+	keep := last - cityWords
+	if ambiguousStateCodes[words[last]] && keep < 2 {
+		return name
+	}
+	if keep < 1 {
+		keep = last // No room for a city, only the state code goes
+	}
+	for _, word := range words[keep:last] {
+		if !cityWordPattern.MatchString(word) {
+			return name
+		}
+	}
+	return strings.Join(words[:keep], " ")
+}
+
+// titleCase capitalizes the first letter of every word and lowers the rest
+func titleCase(s string) string {
+	runes := []rune(strings.ToLower(s))
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) || runes[i-1] == '-' || runes[i-1] == '/' {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestNormalizeApplyAll(t *testing.T) {
+	n := NormalizeConfig{Presets: []string{NormalizeAll}}
+	if err := n.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		raw, want string
+	}{
+		{"POS DEBIT 1234 AMZN MKTP US*2K4 SEATTLE WA", "Amzn Mktp Us"},
+		{"CHECKCARD 0412 SHELL OIL 57444 HOUSTON TX", "Shell Oil"},
+		{"SQ *BLUE BOTTLE COFFEE", "Blue Bottle Coffee"},
+		{"TST* JOES PIZZA", "Joes Pizza"},
+		{"PAYPAL *NETFLIX.COM", "Netflix.com"},
+		{"PAYROLL ACME CO", "Payroll Acme Co"},
+		{"UBER *TRIP", "Uber Trip"},
+		{"STARBUCKS STORE 12345 NEW YORK NY", "Starbucks Store"},
+		{"TARGET T-1234 MINNEAPOLIS MN", "Target"},
+		{"WHOLEFDS MKT #10234 AUSTIN TX", "Wholefds Mkt"},
+		{"COMCAST CABLE 800-266-2278 PA", "Comcast Cable"},
+		{"COMCAST PA", "Comcast"},
+		{"JOES DRIVE IN", "Joes Drive In"},
+		{"BLUE SKY SHOP DENVER CO", "Blue Sky Shop"},
+		{"STARBUCKS NEW YORK NY", "Starbucks"},
+		{"HULU HULU.COM CA", "Hulu"},
+		{"SPOTIFY USA", "Spotify Usa"},
+		{"7-ELEVEN", "7-Eleven"},
+		{"Trader Joe's", "Trader Joe's"},
+		{"12345", "12345"},
+	}
+	for _, tt := range tests {
+		if got := n.Apply(tt.raw); got != tt.want {
+			t.Errorf("Apply(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeReplacements(t *testing.T) {
+	n := NormalizeConfig{
+		Presets:      []string{NormalizeProcessorPrefixes},
+		Replacements: []Replacement{{Pattern: `^amzn mktp.*`, Replace: "Amazon"}, {Pattern: `\bwholefds\b`, Replace: "Whole Foods"}},
+	}
+	if err := n.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		raw, want string
+	}{
+		{"POS DEBIT AMZN MKTP US*2K4", "Amazon"},
+		{"WHOLEFDS MKT", "Whole Foods MKT"},
+		{"Local Bakery", "Local Bakery"},
+	}
+	for _, tt := range tests {
+		if got := n.Apply(tt.raw); got != tt.want {
+			t.Errorf("Apply(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeCompileUnknownPreset(t *testing.T) {
+	n := NormalizeConfig{Presets: []string{"emoji"}}
+	if err := n.compile(); err == nil {
+		t.Error("compile accepted an unknown preset")
+	}
+}
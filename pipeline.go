@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+)
+
+// postBatchSize is the number of operations posted to Sure between ledger commits
+const postBatchSize = 25
+
+// executeSyncOps posts the planned operations to Sure in batches. Each batch
+// journals its creations as intents, posts through a bounded worker pool, then
+// commits all outcomes to the ledger in one transaction and logs them in plan
+// order. If the process dies mid-batch, the intents let RecoverIntents find out
+// which creations reached Sure, so nothing is lost or imported twice.
+func executeSyncOps(config Config, ledger *Ledger, ops []syncOp) TransactionSyncResult {
+	var result TransactionSyncResult
+
+	for start := 0; start < len(ops); start += postBatchSize {
+		batch := ops[start:min(start+postBatchSize, len(ops))]
+
+		var intents []LedgerEntry
+		for _, op := range batch {
+			if op.kind == opCreate {
+				intents = append(intents, op.entry)
+			}
+		}
+		if len(intents) > 0 {
+			if err := ledger.RecordIntents(intents); err != nil {
+				log.Printf("Failed to journal transactions before posting, stopping: %v", err)
+				result.Failed += len(ops) - start
+				return result
+			}
+		}
+
+		runBounded(len(batch), config.PostWorkerCount(), func(i int) {
+			batch[i].execute(config)
+		})
+
+		var commit LedgerBatch
+		for i := range batch {
+			batch[i].record(&commit, &result)
+		}
+		if err := ledger.Commit(commit); err != nil {
+			log.Printf("Warning: Failed to record batch in ledger: %v", err)
+		}
+	}
+
+	return result
+}
+
+// execute performs the operation against Sure and stores the outcome on op
+func (op *syncOp) execute(config Config) {
+	switch op.kind {
+	case opCreate:
+		created, err := CreateSureTransaction(config.SureBaseURL, config.SureAPIKey, op.payload)
+		op.entry.SureTransactionID = created.ID
+		op.err = err
+	case opUpdate, opReplacePending:
+		_, op.err = UpdateSureTransaction(config.SureBaseURL, config.SureAPIKey, op.previous.SureTransactionID, op.update)
+	case opDeletePending:
+		if op.previous.SureTransactionID != "" {
+			op.err = DeleteSureTransaction(config.SureBaseURL, config.SureAPIKey, op.previous.SureTransactionID)
+		}
+	}
+}
+
+// record adds the outcome of an executed operation to the ledger batch, counts and logs it
+func (op *syncOp) record(commit *LedgerBatch, result *TransactionSyncResult) {
+	if op.kind == opCreate {
+		// A status error means Sure rejected the request; anything else (e.g. a
+		// timeout) leaves the intent so the next run can check whether it was created
+		var apiErr *SureAPIError
+		if op.err == nil || errors.As(op.err, &apiErr) {
+			commit.ClearIntents = append(commit.ClearIntents, op.tx.ID)
+		}
+	}
+
+	if op.err != nil {
+		result.Failed++
+		switch op.kind {
+		case opCreate:
+			log.Printf("Failed to create tx %s: %v", op.tx.ID, op.err)
+		case opUpdate:
+			log.Printf("Failed to update tx %s (Sure ID: %s): %v", op.tx.ID, op.previous.SureTransactionID, op.err)
+		case opReplacePending:
+			log.Printf("Failed to update pending tx %s with posted tx %s: %v", op.previous.TransactionID, op.tx.ID, op.err)
+		case opDeletePending:
+			log.Printf("Failed to delete expired pending tx %s (Sure ID: %s): %v", op.previous.TransactionID, op.previous.SureTransactionID, op.err)
+		}
+		return
+	}
+
+	switch op.kind {
+	case opCreate:
+		commit.Put = append(commit.Put, op.entry)
+		result.Created++
+		log.Printf("Synced transaction: %s - %s (Sure ID: %s)", op.payload.Date, op.payload.Name, op.entry.SureTransactionID)
+	case opUpdate:
+		commit.Put = append(commit.Put, op.entry)
+		result.Updated++
+		log.Printf("Updated transaction %s (Sure ID: %s): %s", op.tx.ID, op.entry.SureTransactionID, strings.Join(op.changes, ", "))
+	case opReplacePending:
+		commit.Delete = append(commit.Delete, op.previous.TransactionID)
+		commit.Put = append(commit.Put, op.entry)
+		result.Updated++
+		log.Printf("Matched posted transaction %s to pending %s (Sure ID: %s): %s -> %s",
+			op.tx.ID, op.previous.TransactionID, op.entry.SureTransactionID, op.previous.Amount, op.tx.Amount)
+	case opDeletePending:
+		commit.Delete = append(commit.Delete, op.previous.TransactionID)
+		result.Deleted++
+		log.Printf("Deleted pending transaction %s (%s, %s) that never posted", op.previous.TransactionID, op.previous.Date, op.previous.Amount)
+	}
+}
+
+// RecoverIntents resolves creations whose outcome was never recorded, because the
+// process died or the request failed ambiguously. Sure is searched for a transaction
+// carrying the SimpleFIN ID in its notes: if found it is recorded in the ledger,
+// otherwise the intent is dropped and the transaction is created when next fetched.
+// Sides of a transfer are resolved by recoverTransferIntent.
+func RecoverIntents(config Config, ledger *Ledger) {
+	intents, err := ledger.Intents()
+	if err != nil {
+		log.Printf("Warning: Failed to read unresolved imports: %v", err)
+		return
+	}
+	if len(intents) == 0 {
+		return
+	}
+
+	log.Printf("Checking %d interrupted imports against Sure...", len(intents))
+	for _, intent := range intents {
+		if intent.TransferID != "" {
+			recoverTransferIntent(config, ledger, intent)
+			continue
+		}
+		candidates, err := FetchSureTransactions(config.SureBaseURL, config.SureAPIKey, intent.SureAccountID, intent.Date, intent.Date)
+		if err != nil {
+			log.Printf("Warning: Could not check tx %s in Sure, will retry next run: %v", intent.TransactionID, err)
+			continue
+		}
+
+		commit := LedgerBatch{ClearIntents: []string{intent.TransactionID}}
+		for _, candidate := range candidates {
+			if notesReferenceTransaction(candidate.Notes, intent.TransactionID) {
+				intent.SureTransactionID = candidate.ID
+				commit.Put = []LedgerEntry{intent}
+				break
+			}
+		}
+		if err := ledger.Commit(commit); err != nil {
+			log.Printf("Warning: Failed to resolve tx %s in ledger: %v", intent.TransactionID, err)
+			continue
+		}
+
+		if intent.SureTransactionID != "" {
+			log.Printf("Recovered tx %s: already in Sure (Sure ID: %s)", intent.TransactionID, intent.SureTransactionID)
+		} else {
+			log.Printf("Recovered tx %s: not in Sure, it will be imported again", intent.TransactionID)
+		}
+	}
+}
+
+// notesReferenceTransaction reports whether Sure notes carry the "ID: <id>"
+// traceability marker written at import
+func notesReferenceTransaction(notes, transactionID string) bool {
+	marker := "ID: " + transactionID
+	for rest := notes; ; {
+		idx := strings.Index(rest, marker)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(marker):]
+		if rest == "" || strings.ContainsAny(rest[:1], " \n\t.,;)") {
+			return true
+		}
+	}
+}
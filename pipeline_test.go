@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestNotesReferenceTransaction(t *testing.T) {
+	tests := []struct {
+		notes, id string
+		want      bool
+	}{
+		{"Imported via SimpleFIN. ID: TRN-1", "TRN-1", true},
+		{"[Pending] Imported via SimpleFIN. ID: TRN-1", "TRN-1", true},
+		{"Memo\nImported via SimpleFIN. ID: TRN-1\nmore", "TRN-1", true},
+		{"Transfer imported via SimpleFIN. ID: TRN-1, ID: TRN-2", "TRN-1", true},
+		{"Transfer imported via SimpleFIN. ID: TRN-1, ID: TRN-2", "TRN-2", true},
+		{"Imported via SimpleFIN (ID: TRN-1)", "TRN-1", true},
+		{"Imported via SimpleFIN. ID: TRN-1.", "TRN-1", true},
+		{"Imported via SimpleFIN. ID: TRN-10", "TRN-1", false},
+		{"Imported via SimpleFIN. ID: TRN-10, ID: TRN-1", "TRN-1", true},
+		{"Imported via SimpleFIN. ID: TRN-1a", "TRN-1", false},
+		{"Imported via SimpleFIN. id: TRN-1", "TRN-1", false},
+		{"TRN-1", "TRN-1", false},
+		{"", "TRN-1", false},
+	}
+	for _, tt := range tests {
+		if got := notesReferenceTransaction(tt.notes, tt.id); got != tt.want {
+			t.Errorf("notesReferenceTransaction(%q, %q) = %v, want %v", tt.notes, tt.id, got, tt.want)
+		}
+	}
+}
+
+// openTestLedger opens an empty ledger in a temporary directory
+func openTestLedger(t *testing.T) *Ledger {
+	t.Helper()
+	ledger, err := openLedgerDB(filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger.Close() })
+	return ledger
+}
+
+// createOps plans a creation for each of the transaction IDs
+func createOps(ids ...string) []syncOp {
+	var ops []syncOp
+	for _, id := range ids {
+		tx := SFTransaction{ID: id, Amount: "-1.00", Description: "Coffee"}
+		payload := SureTransaction{AccountID: "sure-acc", Amount: tx.Amount, Date: "2024-01-02", Name: "Coffee", Notes: "Imported via SimpleFIN. ID: " + id}
+		ops = append(ops, syncOp{kind: opCreate, tx: tx, payload: payload, entry: newLedgerEntry("sf-acc", tx, payload)})
+	}
+	return ops
+}
+
+func TestExecuteSyncOpsCreatesInBatches(t *testing.T) {
+	var mu sync.Mutex
+	posted := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Transaction SureTransaction `json:"transaction"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		id := body.Transaction.Notes[len("Imported via SimpleFIN. ID: "):]
+		if id == "TRN-7" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"error":"invalid"}`)
+			return
+		}
+		mu.Lock()
+		posted[id] = true
+		mu.Unlock()
+		fmt.Fprintf(w, `{"id":"sure-%s"}`, id)
+	}))
+	defer server.Close()
+
+	var ids []string
+	for i := range postBatchSize + 5 {
+		ids = append(ids, fmt.Sprintf("TRN-%d", i))
+	}
+	ledger := openTestLedger(t)
+	result := executeSyncOps(Config{SureBaseURL: server.URL}, ledger, createOps(ids...))
+
+	if result.Created != len(ids)-1 || result.Failed != 1 {
+		t.Fatalf("result = %+v, want %d created and 1 failed", result, len(ids)-1)
+	}
+	for _, id := range ids {
+		entry, ok, err := ledger.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id == "TRN-7" {
+			if ok {
+				t.Errorf("rejected %s was recorded in the ledger", id)
+			}
+			continue
+		}
+		if !ok || entry.SureTransactionID != "sure-"+id {
+			t.Errorf("ledger entry for %s = %+v, %v", id, entry, ok)
+		}
+	}
+	if intents, _ := ledger.Intents(); len(intents) != 0 {
+		t.Errorf("%d intents left after every outcome was known", len(intents))
+	}
+}
+
+func TestExecuteSyncOpsKeepsIntentWhenOutcomeUnknown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Drop the connection: Sure may or may not have created the transaction
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	ledger := openTestLedger(t)
+	result := executeSyncOps(Config{SureBaseURL: server.URL}, ledger, createOps("TRN-1"))
+
+	if result.Failed != 1 {
+		t.Fatalf("result = %+v, want 1 failed", result)
+	}
+	if !ledger.HasIntent("TRN-1") {
+		t.Error("intent was cleared although the creation may have reached Sure")
+	}
+	if _, ok, _ := ledger.Get("TRN-1"); ok {
+		t.Error("transaction recorded as imported without a Sure ID")
+	}
+}
+
+func TestRecoverIntentsSearchesEveryPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("account_id") != "sure-acc" || q.Get("start_date") != "2024-01-02" || q.Get("end_date") != "2024-01-02" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		switch q.Get("page") {
+		case "1":
+			fmt.Fprint(w, `{"transactions":[{"id":"s-other","notes":"Imported via SimpleFIN. ID: TRN-2"}],"pagination":{"page":1,"total_pages":2}}`)
+		default:
+			fmt.Fprint(w, `{"transactions":[{"id":"s-1","notes":"Imported via SimpleFIN. ID: TRN-1"}],"pagination":{"page":2,"total_pages":2}}`)
+		}
+	}))
+	defer server.Close()
+
+	ledger := openTestLedger(t)
+	ops := createOps("TRN-1")
+	if err := ledger.RecordIntents([]LedgerEntry{ops[0].entry}); err != nil {
+		t.Fatal(err)
+	}
+	RecoverIntents(Config{SureBaseURL: server.URL}, ledger)
+
+	if entry, ok, _ := ledger.Get("TRN-1"); !ok || entry.SureTransactionID != "s-1" {
+		t.Errorf("ledger entry = %+v, %v, want the transaction found on page 2", entry, ok)
+	}
+	if ledger.HasIntent("TRN-1") {
+		t.Error("intent left after the transaction was found")
+	}
+}
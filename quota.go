@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	quotaFile = "simplefin_quota.json"
+	// SimpleFIN Bridge allows roughly 24 requests per token per day
+	defaultDailyRequestBudget = 24
+)
+
+// ErrDeferred marks an account skipped because the daily request budget would be exceeded
+var ErrDeferred = errors.New("deferred to next run, SimpleFIN daily request budget reached")
+
+// RequestQuota counts the SimpleFIN requests made on the current (UTC) day.
+// It is safe for concurrent use by the account fetchers.
+type RequestQuota struct {
+	Date     string `json:"date"`
+	Requests int    `json:"requests"`
+
+	budget   int // Negative means unlimited
+	reserved int // Planned requests not made yet
+	mu       sync.Mutex
+}
+
+// LoadRequestQuota loads today's request count from disk, starting from zero on a new day
+func LoadRequestQuota(budget int) *RequestQuota {
+	today := time.Now().UTC().Format("2006-01-02")
+	quota := &RequestQuota{}
+	if file, err := os.ReadFile(quotaFile); err == nil {
+		json.Unmarshal(file, quota)
+	}
+	if quota.Date != today {
+		quota.Date = today
+		quota.Requests = 0
+	}
+	quota.budget = budget
+	return quota
+}
+
+// Save writes the request count to disk
+func (q *RequestQuota) Save() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	data, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(quotaFile, data, 0644)
+}
+
+// Reserve sets aside requests planned for this run, so that retries of earlier
+// requests cannot use up the budget the plan counted on
+func (q *RequestQuota) Reserve(requests int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reserved += requests
+}
+
+// Attempt counts one request before it is sent, retries included. A first attempt
+// is always made and uses up a reserved request if there is one; a retry is only
+// allowed while the budget covers it on top of the requests still reserved.
+func (q *RequestQuota) Attempt(retry bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !retry {
+		q.reserved = max(q.reserved-1, 0)
+	} else if q.budget >= 0 && q.Requests+q.reserved >= q.budget {
+		return false
+	}
+	q.Requests++
+	return true
+}
+
+// Remaining returns how many requests may still be made today, not counting reserved ones
+func (q *RequestQuota) Remaining() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.budget < 0 {
+		return math.MaxInt
+	}
+	return max(q.budget-q.Requests-q.reserved, 0)
+}
+
+// fetchWindow is the date range of transactions to fetch for one account
+type fetchWindow struct {
+	account *SFAccount
+	start   int64
+	end     int64
+	pending bool
+}
+
+// pages returns the number of 90-day requests needed to cover the window
+func (w fetchWindow) pages() int {
+	return pageCount(w.start, w.end)
+}
+
+// fetchPlan decides how the transaction windows are fetched within the budget
+type fetchPlan struct {
+	combined bool          // Fetch all accounts together, one request per 90-day page
+	windows  []fetchWindow // Accounts to fetch this run
+	deferred []fetchWindow // Accounts left for the next run
+	requests int           // Requests the plan will make
+}
+
+// planFetch picks the cheaper of fetching every account together or one account
+// at a time. If the budget cannot cover everything, accounts are fetched one at a
+// time in order and those that no longer fit are deferred. The planned requests are
+// reserved in the quota by the caller; retries are counted as they happen and only
+// made from what is left over.
+func planFetch(windows []fetchWindow, remaining int) fetchPlan {
+	if len(windows) == 0 {
+		return fetchPlan{}
+	}
+
+	perAccount := 0
+	start, end := windows[0].start, windows[0].end
+	for _, w := range windows {
+		perAccount += w.pages()
+		start = min(start, w.start)
+		end = max(end, w.end)
+	}
+	combined := pageCount(start, end)
+
+	if combined < perAccount && combined <= remaining {
+		return fetchPlan{combined: true, windows: windows, requests: combined}
+	}
+	if perAccount <= remaining {
+		return fetchPlan{windows: windows, requests: perAccount}
+	}
+
+	log.Printf("%sWarning: SimpleFIN fetch needs %d requests but only %d remain in today's budget%s", colorRed, perAccount, remaining, colorReset)
+	plan := fetchPlan{}
+	for _, w := range windows {
+		if plan.requests+w.pages() > remaining {
+			plan.deferred = append(plan.deferred, w)
+			continue
+		}
+		plan.windows = append(plan.windows, w)
+		plan.requests += w.pages()
+	}
+	return plan
+}
+
+// pageCount returns the number of 90-day pages between two Unix timestamps
+func pageCount(start, end int64) int {
+	if end <= start {
+		return 0
+	}
+	return int((end - start + maxRangeSeconds - 1) / maxRangeSeconds)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+func TestPlanFetch(t *testing.T) {
+	now := int64(1_700_000_000)
+	window := func(id string, days int64) fetchWindow {
+		return fetchWindow{account: &SFAccount{ID: id}, start: now - days*secondsPerDay, end: now}
+	}
+	older := func(id string, days, ago int64) fetchWindow {
+		return fetchWindow{account: &SFAccount{ID: id}, start: now - (ago+days)*secondsPerDay, end: now - ago*secondsPerDay}
+	}
+
+	tests := []struct {
+		name      string
+		windows   []fetchWindow
+		remaining int
+		combined  bool
+		fetched   []string
+		deferred  []string
+		requests  int
+	}{
+		{"nothing to fetch", nil, 10, false, nil, nil, 0},
+		{"together is cheaper", []fetchWindow{window("a", 30), window("b", 30), window("c", 30)}, 10, true, []string{"a", "b", "c"}, nil, 1},
+		{"one account", []fetchWindow{window("a", 200)}, 10, false, []string{"a"}, nil, 3},
+		{"overlapping windows together", []fetchWindow{window("a", 365), window("b", 10)}, 10, true, []string{"a", "b"}, nil, 5},
+		{"far apart windows one by one", []fetchWindow{window("a", 10), older("b", 10, 300)}, 10, false, []string{"a", "b"}, nil, 2},
+		{"nothing fits", []fetchWindow{window("a", 10), window("b", 10)}, 0, false, nil, []string{"a", "b"}, 0},
+		{"defer what does not fit", []fetchWindow{window("a", 200), window("b", 10), window("c", 100)}, 2, false, []string{"b"}, []string{"a", "c"}, 1},
+	}
+	for _, tt := range tests {
+		plan := planFetch(tt.windows, tt.remaining)
+		if plan.combined != tt.combined || plan.requests != tt.requests {
+			t.Errorf("%s: combined=%v requests=%d, want combined=%v requests=%d", tt.name, plan.combined, plan.requests, tt.combined, tt.requests)
+		}
+		if got := windowIDs(plan.windows); !slices.Equal(got, tt.fetched) {
+			t.Errorf("%s: fetched %v, want %v", tt.name, got, tt.fetched)
+		}
+		if got := windowIDs(plan.deferred); !slices.Equal(got, tt.deferred) {
+			t.Errorf("%s: deferred %v, want %v", tt.name, got, tt.deferred)
+		}
+		if plan.requests > tt.remaining {
+			t.Errorf("%s: plan needs %d requests, only %d remain", tt.name, plan.requests, tt.remaining)
+		}
+	}
+}
+
+func TestRequestQuotaRetriesUseOnlySpareBudget(t *testing.T) {
+	q := &RequestQuota{budget: 5}
+	q.Reserve(3)
+	if got := q.Remaining(); got != 2 {
+		t.Fatalf("Remaining = %d after reserving 3 of 5, want 2", got)
+	}
+
+	q.Attempt(false) // First planned request
+	if !q.Attempt(true) || !q.Attempt(true) {
+		t.Fatal("retries refused while spare budget remained")
+	}
+	if q.Attempt(true) {
+		t.Error("retry allowed into requests reserved for the plan")
+	}
+	q.Attempt(false)
+	q.Attempt(false)
+	if q.Requests != 5 || q.Remaining() != 0 {
+		t.Errorf("Requests = %d, Remaining = %d, want 5 and 0", q.Requests, q.Remaining())
+	}
+
+	unlimited := &RequestQuota{budget: -1}
+	if !unlimited.Attempt(true) {
+		t.Error("retry refused without a budget")
+	}
+}
+
+func windowIDs(windows []fetchWindow) []string {
+	var ids []string
+	for _, w := range windows {
+		ids = append(ids, w.account.ID)
+	}
+	return ids
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// BalanceDrift describes the difference between SimpleFIN and Sure for one mapped account
+type BalanceDrift struct {
+	Name            string
+	SimpleFINAmount float64
+	SureAmount      float64
+	Drift           float64
+	Currency        string
+	BalanceAge      time.Duration
+	Exceeded        bool
+	Action          string
+}
+
+// ReconcileBalances compares SimpleFIN balances with the balances Sure reports for
+// each mapped account, prints a drift table and applies the configured drift actions.
+// It returns true if any account with DriftActionFail exceeded its threshold.
+func ReconcileBalances(config Config, accounts []SFAccount, syncState map[string]AccountSyncState) bool {
+	log.Println("Reconciling balances with Sure...")
+	sureAccounts, err := FetchSureAccounts(config.SureBaseURL, config.SureAPIKey)
+	if err != nil {
+		log.Printf("Skipping reconciliation, failed to fetch Sure accounts: %v", err)
+		return false
+	}
+
+	sureAccountsMap := make(map[string]SureAccount)
+	for _, acc := range sureAccounts {
+		sureAccountsMap[acc.ID] = acc
+	}
+
+	var drifts []BalanceDrift
+	failed := false
+	for _, account := range accounts {
+		accConfig, mapped := config.AccountMap[account.ID]
+		if !mapped || accConfig.Closed {
+			continue
+		}
+		sureAcc, ok := sureAccountsMap[accConfig.SureID]
+		if !ok {
+			log.Printf("Warning: Mapped Sure account %s not found in Sure", accConfig.SureID)
+			continue
+		}
+
+		sfAmount, err := parseAmount(accConfig.sureBalance(account.Balance))
+		if err != nil {
+			log.Printf("Warning: Cannot parse SimpleFIN balance %q for %s: %v", account.Balance, accConfig.Name, err)
+			continue
+		}
+		sureAmount, err := parseAmount(sureAcc.Balance)
+		if err != nil {
+			log.Printf("Warning: Cannot parse Sure balance %q for %s: %v", sureAcc.Balance, accConfig.Name, err)
+			continue
+		}
+
+		drift := BalanceDrift{
+			Name:            accConfig.Name,
+			SimpleFINAmount: sfAmount,
+			SureAmount:      sureAmount,
+			Drift:           roundCents(sfAmount - sureAmount),
+			Currency:        sureAcc.Currency,
+			BalanceAge:      time.Since(balanceTime(account)),
+			Action:          accConfig.DriftAction,
+		}
+		if drift.Action == "" {
+			drift.Action = DriftActionWarn
+		}
+		drift.Exceeded = math.Abs(drift.Drift) > accConfig.DriftThreshold
+
+		if drift.Exceeded {
+			switch drift.Action {
+			case DriftActionFail:
+				failed = true
+			case DriftActionCorrect:
+				if err := pushAccountBalance(config, accConfig, account, syncState); err != nil {
+					log.Printf("Failed to post correcting valuation for %s: %v", accConfig.Name, err)
+				}
+			}
+		}
+		drifts = append(drifts, drift)
+	}
+
+	printDriftTable(drifts)
+	return failed
+}
+
+// printDriftTable prints the reconciliation report as an aligned table
+func printDriftTable(drifts []BalanceDrift) {
+	out := reportOutput()
+	fmt.Fprintln(out, "\nBalance Reconciliation:")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tSIMPLEFIN\tSURE\tDRIFT\tCURRENCY\tBALANCE AGE\tSTATUS")
+	for _, d := range drifts {
+		status := "ok"
+		if d.Exceeded {
+			status = colorRed + d.Action + colorReset
+		}
+		fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%.2f\t%s\t%s\t%s\n",
+			d.Name, d.SimpleFINAmount, d.SureAmount, d.Drift, d.Currency, formatAge(d.BalanceAge), status)
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+}
+
+// parseAmount parses a plain or currency-formatted amount such as "-1234.56" or "$1,234.56"
+func parseAmount(s string) (float64, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return -1
+	}, s)
+	return strconv.ParseFloat(cleaned, 64)
+}
+
+// roundCents rounds an amount to two decimal places
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// formatAge renders a duration as whole days or hours
+func formatAge(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+	return fmt.Sprintf("%dh", int(d.Hours()))
+}
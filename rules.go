@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const rulesFile = "rules.json"
+
+// Rule categorizes the transactions it matches. All conditions that are set must
+// match; the first matching rule in the file wins.
+type Rule struct {
+	Name    string      `json:"name"`
+	Match   RuleMatch   `json:"match"`
+	Actions RuleActions `json:"actions"`
+
+	description *regexp.Regexp
+	payee       *regexp.Regexp
+}
+
+// RuleMatch holds the conditions of a rule
+type RuleMatch struct {
+	Description string   `json:"description,omitzero"` // Regular expression on the raw SimpleFIN description
+	Payee       string   `json:"payee,omitzero"`       // Regular expression on the payee
+	MinAmount   *float64 `json:"min_amount,omitempty"` // Inclusive, on the amount as imported (after invert_amounts)
+	MaxAmount   *float64 `json:"max_amount,omitempty"` // Inclusive
+	Account     string   `json:"account,omitzero"`     // SimpleFIN account ID or mapped account name
+	OrgDomain   string   `json:"org_domain,omitzero"`  // Institution domain, e.g. chase.com
+}
+
+// RuleActions holds what a rule sets on the Sure transaction. Category, tags and
+// merchant are given by name and looked up in Sure.
+type RuleActions struct {
+	Category string   `json:"category,omitzero"`
+	Tags     []string `json:"tags,omitempty"`
+	Merchant string   `json:"merchant,omitzero"`
+	Name     string   `json:"name,omitzero"` // Replaces the transaction name
+}
+
+// RuleSet is the list of rules loaded from rules.json with the Sure IDs of the
+// names they use. A nil RuleSet has no rules.
+type RuleSet struct {
+	Rules []Rule `json:"rules"`
+
+	categories map[string]string // Lowercased name -> Sure ID
+	tags       map[string]string
+	merchants  map[string]string
+}
+
+// LoadRules reads rules.json, returning nil if there is none
+func LoadRules() (*RuleSet, error) {
+	file, err := os.ReadFile(rulesFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules RuleSet
+	if err := json.Unmarshal(file, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", rulesFile, err)
+	}
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.description, err = compileRulePattern(rule.Match.Description); err != nil {
+			return nil, fmt.Errorf("%s: description: %w", rule.Name, err)
+		}
+		if rule.payee, err = compileRulePattern(rule.Match.Payee); err != nil {
+			return nil, fmt.Errorf("%s: payee: %w", rule.Name, err)
+		}
+	}
+	return &rules, nil
+}
+
+// loadRules loads rules.json and resolves its names in Sure, stopping on errors so
+// that transactions are never imported uncategorized by mistake
+func loadRules(config Config) *RuleSet {
+	rules, err := LoadRules()
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", rulesFile, err)
+	}
+	if rules == nil {
+		return nil
+	}
+	if err := rules.ResolveNames(config); err != nil {
+		log.Fatalf("Failed to resolve names used in %s: %v", rulesFile, err)
+	}
+	log.Printf("Loaded %d rules from %s", len(rules.Rules), rulesFile)
+	return rules
+}
+
+// compileRulePattern compiles a case-insensitive pattern, nil when empty
+func compileRulePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// ResolveNames looks up the Sure IDs of the categories, tags and merchants used by
+// the rules. Names missing from Sure are reported and left unset when applied.
+func (rs *RuleSet) ResolveNames(config Config) error {
+	if rs == nil {
+		return nil
+	}
+
+	var needCategories, needTags, needMerchants bool
+	for _, rule := range rs.Rules {
+		needCategories = needCategories || rule.Actions.Category != ""
+		needTags = needTags || len(rule.Actions.Tags) > 0
+		needMerchants = needMerchants || rule.Actions.Merchant != ""
+	}
+
+	var err error
+	if needCategories {
+		if rs.categories, err = FetchSureNames(config.SureBaseURL, config.SureAPIKey, "categories"); err != nil {
+			return fmt.Errorf("fetch Sure categories: %w", err)
+		}
+	}
+	if needTags {
+		if rs.tags, err = FetchSureNames(config.SureBaseURL, config.SureAPIKey, "tags"); err != nil {
+			return fmt.Errorf("fetch Sure tags: %w", err)
+		}
+	}
+	if needMerchants {
+		if rs.merchants, err = FetchSureNames(config.SureBaseURL, config.SureAPIKey, "merchants"); err != nil {
+			return fmt.Errorf("fetch Sure merchants: %w", err)
+		}
+	}
+
+	for _, rule := range rs.Rules {
+		warnMissingName(rs.categories, "category", rule.Actions.Category, rule.Name)
+		for _, tag := range rule.Actions.Tags {
+			warnMissingName(rs.tags, "tag", tag, rule.Name)
+		}
+		warnMissingName(rs.merchants, "merchant", rule.Actions.Merchant, rule.Name)
+	}
+	return nil
+}
+
+// warnMissingName reports a name used by a rule that Sure does not know
+func warnMissingName(ids map[string]string, kind, name, ruleName string) {
+	if name == "" {
+		return
+	}
+	if _, ok := ids[strings.ToLower(name)]; !ok {
+		log.Printf("%sWarning: %s %q used by rule %q does not exist in Sure%s", colorRed, kind, name, ruleName, colorReset)
+	}
+}
+
+// Match returns the first rule matching the transaction, or nil
+func (rs *RuleSet) Match(tx SFTransaction, account SFAccount, accConfig AccountConfig) *Rule {
+	if rs == nil {
+		return nil
+	}
+	for i := range rs.Rules {
+		if rs.Rules[i].mismatch(tx, account, accConfig) == "" {
+			return &rs.Rules[i]
+		}
+	}
+	return nil
+}
+
+// Apply sets the actions of the first matching rule on the Sure transaction
+func (rs *RuleSet) Apply(payload *SureTransaction, tx SFTransaction, account SFAccount, accConfig AccountConfig) {
+	rule := rs.Match(tx, account, accConfig)
+	if rule == nil {
+		return
+	}
+
+	if rule.Actions.Name != "" {
+		payload.Name = rule.Actions.Name
+	}
+	if id, ok := rs.categories[strings.ToLower(rule.Actions.Category)]; ok {
+		payload.CategoryID = id
+	}
+	for _, tag := range rule.Actions.Tags {
+		if id, ok := rs.tags[strings.ToLower(tag)]; ok {
+			payload.TagIDs = append(payload.TagIDs, id)
+		}
+	}
+	if id, ok := rs.merchants[strings.ToLower(rule.Actions.Merchant)]; ok {
+		payload.MerchantID = id
+	}
+}
+
+// mismatch returns the first condition of the rule the transaction fails, or "" if it matches
+func (r Rule) mismatch(tx SFTransaction, account SFAccount, accConfig AccountConfig) string {
+	m := r.Match
+	if r.description != nil && !r.description.MatchString(tx.Description) {
+		return fmt.Sprintf("description %q does not match %q", tx.Description, m.Description)
+	}
+	if r.payee != nil && !r.payee.MatchString(tx.Payee) {
+		return fmt.Sprintf("payee %q does not match %q", tx.Payee, m.Payee)
+	}
+	if m.MinAmount != nil || m.MaxAmount != nil {
+		imported := accConfig.sureAmount(tx.Amount)
+		amount, err := parseAmount(imported)
+		if err != nil {
+			return fmt.Sprintf("amount %q is not a number", tx.Amount)
+		}
+		if m.MinAmount != nil && amount < *m.MinAmount {
+			return fmt.Sprintf("amount %s is below %g", imported, *m.MinAmount)
+		}
+		if m.MaxAmount != nil && amount > *m.MaxAmount {
+			return fmt.Sprintf("amount %s is above %g", imported, *m.MaxAmount)
+		}
+	}
+	if m.Account != "" && m.Account != account.ID && !strings.EqualFold(m.Account, accConfig.Name) {
+		return fmt.Sprintf("account %s (%s) is not %q", accConfig.Name, account.ID, m.Account)
+	}
+	if m.OrgDomain != "" && !strings.EqualFold(m.OrgDomain, account.Org.Domain) {
+		return fmt.Sprintf("institution %q is not %q", account.Org.Domain, m.OrgDomain)
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeRules writes rules.json into a temporary working directory and loads it
+func writeRules(t *testing.T, content string) (*RuleSet, error) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile(rulesFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadRules()
+}
+
+func TestLoadRules(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if rules, err := LoadRules(); rules != nil || err != nil {
+		t.Errorf("LoadRules without rules.json = %v, %v, want nil, nil", rules, err)
+	}
+
+	rules, err := writeRules(t, `{"rules":[{"match":{"payee":"coffee"}},{"name":"Named","match":{}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.Rules[0].Name != "rule 1" || rules.Rules[1].Name != "Named" {
+		t.Errorf("rule names = %q, %q", rules.Rules[0].Name, rules.Rules[1].Name)
+	}
+
+	for _, bad := range []string{`{"rules":[{"match":{"description":"("}}]}`, `{"rules":[{"match":{"payee":"[a-"}}]}`, `{"rules":`} {
+		if _, err := writeRules(t, bad); err == nil {
+			t.Errorf("no error loading %s", bad)
+		}
+	}
+}
+
+func TestRuleSetApply(t *testing.T) {
+	rules, err := writeRules(t, `{"rules":[
+		{"name":"Payroll", "match":{"description":"^payroll", "min_amount":0}, "actions":{"category":"Income", "name":"Salary"}},
+		{"name":"Big groceries", "match":{"payee":"grocer", "max_amount":-100}, "actions":{"category":"Groceries", "tags":["Bulk", "Missing"]}},
+		{"name":"Groceries", "match":{"payee":"grocer"}, "actions":{"category":"Groceries", "merchant":"Corner Grocer"}},
+		{"name":"Card fees", "match":{"account":"Visa", "min_amount":-10, "max_amount":-5}, "actions":{"category":"Fees"}},
+		{"name":"Bank fees", "match":{"org_domain":"chase.com", "description":"fee"}, "actions":{"category":"Fees", "tags":["Bank"]}}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules.categories = map[string]string{"income": "c-income", "groceries": "c-groceries", "fees": "c-fees"}
+	rules.tags = map[string]string{"bulk": "t-bulk", "bank": "t-bank"}
+	rules.merchants = map[string]string{"corner grocer": "m-grocer"}
+
+	checking := SFAccount{ID: "chk", Org: SFOrg{Domain: "chase.com"}}
+	visa := SFAccount{ID: "visa", Org: SFOrg{Domain: "amex.com"}}
+	chkConfig := AccountConfig{Name: "Checking"}
+	visaConfig := AccountConfig{Name: "Visa", invert: true}
+
+	tests := []struct {
+		name      string
+		tx        SFTransaction
+		account   SFAccount
+		accConfig AccountConfig
+		want      SureTransaction
+	}{
+		{"rename and category", SFTransaction{Description: "PAYROLL ACME", Amount: "2500.00"}, checking, chkConfig,
+			SureTransaction{Name: "Salary", CategoryID: "c-income"}},
+		{"amount below the range", SFTransaction{Description: "PAYROLL REVERSAL", Amount: "-2500.00"}, checking, chkConfig,
+			SureTransaction{Name: "original"}},
+		{"first match wins", SFTransaction{Payee: "Corner Grocer", Amount: "-150.00"}, checking, chkConfig,
+			SureTransaction{Name: "original", CategoryID: "c-groceries", TagIDs: []string{"t-bulk"}}},
+		{"inclusive bound", SFTransaction{Payee: "Corner Grocer", Amount: "-100.00"}, checking, chkConfig,
+			SureTransaction{Name: "original", CategoryID: "c-groceries", TagIDs: []string{"t-bulk"}}},
+		{"next rule when out of range", SFTransaction{Payee: "corner GROCER", Amount: "-20.00"}, checking, chkConfig,
+			SureTransaction{Name: "original", CategoryID: "c-groceries", MerchantID: "m-grocer"}},
+		{"account by name, inverted amount", SFTransaction{Description: "LATE FEE", Amount: "7.00"}, visa, visaConfig,
+			SureTransaction{Name: "original", CategoryID: "c-fees"}},
+		{"inverted amount out of range", SFTransaction{Description: "ANNUAL FEE", Amount: "95.00"}, visa, visaConfig,
+			SureTransaction{Name: "original"}},
+		{"institution", SFTransaction{Description: "Monthly fee", Amount: "-12.00"}, checking, chkConfig,
+			SureTransaction{Name: "original", CategoryID: "c-fees", TagIDs: []string{"t-bank"}}},
+		{"no match", SFTransaction{Description: "BOOKSHOP", Amount: "-4.00"}, checking, chkConfig,
+			SureTransaction{Name: "original"}},
+	}
+	for _, tt := range tests {
+		payload := SureTransaction{Name: "original"}
+		rules.Apply(&payload, tt.tx, tt.account, tt.accConfig)
+		if payload.Name != tt.want.Name || payload.CategoryID != tt.want.CategoryID || payload.MerchantID != tt.want.MerchantID ||
+			!slices.Equal(payload.TagIDs, tt.want.TagIDs) {
+			t.Errorf("%s: Apply = %+v, want %+v", tt.name, payload, tt.want)
+		}
+	}
+
+	var none *RuleSet
+	payload := SureTransaction{Name: "original"}
+	none.Apply(&payload, SFTransaction{Description: "PAYROLL"}, checking, chkConfig)
+	if payload.Name != "original" || none.Match(SFTransaction{}, checking, chkConfig) != nil {
+		t.Error("a nil RuleSet changed the transaction")
+	}
+}
+
+func TestRuleMismatchExplains(t *testing.T) {
+	rules, err := writeRules(t, `{"rules":[{"match":{"account":"Savings","min_amount":1}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reason := rules.Rules[0].mismatch(SFTransaction{Amount: "5.00"}, SFAccount{ID: "chk"}, AccountConfig{Name: "Checking"})
+	if !strings.Contains(reason, "Checking") || !strings.Contains(reason, "Savings") {
+		t.Errorf("mismatch = %q, want it to name both accounts", reason)
+	}
+}
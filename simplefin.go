@@ -1,18 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
 const (
 	cacheDir = "tmp"
+	// Currency assumed when SimpleFIN does not report one
+	defaultCurrency = "USD"
 	// 90 days in seconds
 	maxRangeSeconds = 90 * 24 * 60 * 60
 	// ANSI color codes
@@ -34,7 +39,15 @@ type SFAccount struct {
 	Balance          string          `json:"balance"`
 	AvailableBalance string          `json:"available-balance"`
 	BalanceDate      uint64          `json:"balance-date"`
+	Currency         string          `json:"currency"` // ISO 4217 code or URL of a custom currency
 	Transactions     []SFTransaction `json:"transactions"`
+	Holdings         []SFHolding     `json:"holdings,omitempty"`
+
+	Extra map[string]interface{} `json:"extra,omitempty"` // Institution specific fields
+
+	// End of the transaction window fetched this run, 0 if transactions were not fetched.
+	// LastSyncDate advances to it once the transactions are imported.
+	SyncedThrough int64 `json:"-"`
 }
 
 // SFOrg represents the financial institution
@@ -43,18 +56,37 @@ type SFOrg struct {
 	SfinURL string `json:"sfin-url"`
 }
 
+// SFHolding represents a position held in a SimpleFIN investment account
+type SFHolding struct {
+	ID            string `json:"id"`
+	Created       int64  `json:"created"`
+	Currency      string `json:"currency"`
+	CostBasis     string `json:"cost_basis"`
+	Description   string `json:"description"`
+	MarketValue   string `json:"market_value"`
+	PurchasePrice string `json:"purchase_price"`
+	Shares        string `json:"shares"`
+	Symbol        string `json:"symbol"`
+}
+
+// SFCustomCurrency describes a non-ISO currency published at a SimpleFIN currency URL
+type SFCustomCurrency struct {
+	Name string `json:"name"`
+	Abbr string `json:"abbr"`
+}
+
 // SFTransaction represents a SimpleFIN transaction
 type SFTransaction struct {
 	ID           string `json:"id"`
 	Amount       string `json:"amount"`
 	Description  string `json:"description"`
 	TransactedAt int64  `json:"transacted_at"`
-}
+	Posted       int64  `json:"posted"` // 0 while pending
+	Pending      bool   `json:"pending,omitempty"`
+	Payee        string `json:"payee,omitempty"`
+	Memo         string `json:"memo,omitempty"`
 
-// CachedAccount holds cached account data with timestamp
-type CachedAccount struct {
-	Account   SFAccount `json:"account"`
-	FetchedAt time.Time `json:"fetched_at"`
+	Extra map[string]interface{} `json:"extra,omitempty"` // Institution specific fields
 }
 
 // ClaimSimpleFINToken exchanges a setup token for a permanent access URL
@@ -66,7 +98,7 @@ func ClaimSimpleFINToken(setupToken string) string {
 	claimURL := string(decoded)
 
 	req, _ := http.NewRequest("POST", claimURL, nil)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := simplefinClient.Do(req)
 	if err != nil || resp.StatusCode != 200 {
 		log.Fatalf("Failed to claim token at %s", claimURL)
 	}
@@ -76,103 +108,156 @@ func ClaimSimpleFINToken(setupToken string) string {
 	return string(body) // This is the permanent Access URL
 }
 
-// FetchSimpleFINData fetches accounts and transactions from SimpleFIN
-func FetchSimpleFINData(accessURL string, forceRefresh bool, config Config) SimpleFINResponse {
+// AccountFetchError records a SimpleFIN account whose transactions could not be fetched
+type AccountFetchError struct {
+	AccountID   string
+	AccountName string
+	Err         error
+}
+
+func (e AccountFetchError) Error() string {
+	return fmt.Sprintf("account %s (%s): %v", e.AccountName, e.AccountID, e.Err)
+}
+
+// FetchOptions controls how FetchSimpleFINData uses the tmp/ cache
+type FetchOptions struct {
+	ForceRefresh bool // Ignore cached data and always fetch from SimpleFIN
+	Offline      bool // Use only cached data and never contact SimpleFIN
+	AutoCreate   bool // Unmapped accounts may be mapped this run, so fetch their transactions too
+}
+
+// FetchSimpleFINData fetches accounts and transactions from SimpleFIN, reusing data
+// cached within the configured TTL. Each account's window starts at its last sync
+// date minus the configured lookback. An error is returned only if the account list
+// itself cannot be fetched; accounts whose transactions fail or are deferred by the
+// daily request budget are reported individually and have no SyncedThrough date.
+// The sync state is only read: the caller advances it once transactions are imported.
+func FetchSimpleFINData(accessURL string, opts FetchOptions, config Config, ledger *Ledger, accountSyncState map[string]AccountSyncState) (SimpleFINResponse, []AccountFetchError, error) {
 	if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
 		os.Mkdir(cacheDir, 0755)
 	}
 
-	// Load account sync state to track last sync dates
-	accountSyncState := LoadAccountSyncState()
+	quota := LoadRequestQuota(config.DailyRequestBudget())
+	defer func() {
+		if err := quota.Save(); err != nil {
+			log.Printf("Warning: Failed to save SimpleFIN request count: %v", err)
+		}
+	}()
+	fetcher := &simplefinFetcher{accessURL: accessURL, quota: quota, workers: config.FetchWorkerCount()}
 
-	// Step 1: Fetch accounts with balances only (no transactions)
-	log.Println("Fetching account balances from SimpleFIN...")
-	balancesURL := accessURL + "/accounts?balances-only=1"
-	resp, err := http.Get(balancesURL)
-	if err != nil || resp.StatusCode != 200 {
-		log.Fatalf("Failed to fetch SimpleFIN balances. Status: %v", resp.StatusCode)
+	ttl := config.CacheTTL()
+	if opts.ForceRefresh {
+		ttl = 0
 	}
-	defer resp.Body.Close()
 
-	bodyBytes, _ := io.ReadAll(resp.Body)
+	// Step 1: Fetch accounts with balances only (no transactions)
 	var sfResp SimpleFINResponse
-	if err := json.Unmarshal(bodyBytes, &sfResp); err != nil {
-		log.Fatalf("Failed to decode SimpleFIN response: %v\nResponse body: %s", err, string(bodyBytes))
+	cachedBalances, haveCachedBalances := LoadCachedBalances()
+	switch {
+	case opts.Offline && !haveCachedBalances:
+		return sfResp, nil, fmt.Errorf("offline mode: no cached account list in %s, run once online first", cacheDir)
+	case opts.Offline || (haveCachedBalances && cachedBalances.Fresh(ttl)):
+		log.Printf("Using cached account balances from %s", cachedBalances.FetchedAt.Format(time.RFC3339))
+		sfResp.Accounts = cachedBalances.Accounts
+	default:
+		log.Println("Fetching account balances from SimpleFIN...")
+		if quota.Remaining() < 1 {
+			return sfResp, nil, fmt.Errorf("SimpleFIN daily request budget exhausted (%d requests made today)", quota.Requests)
+		}
+		var err error
+		sfResp, err = fetcher.fetchAccounts(accessURL + "/accounts?balances-only=1")
+		if err != nil {
+			return sfResp, nil, fmt.Errorf("fetch SimpleFIN balances: %w", err)
+		}
+		printSimpleFINErrors(log.Writer(), sfResp.Errors)
+		if err := SaveCachedBalances(sfResp.Accounts); err != nil {
+			log.Printf("Warning: Failed to cache account balances: %v", err)
+		}
 	}
 
-	// Print any errors from SimpleFIN
-	printSimpleFINErrors(sfResp.Errors)
-
 	log.Printf("Found %d accounts\n", len(sfResp.Accounts))
 
-	// Step 2: Fetch transactions for each account individually in 90-day increments
-	totalTransactions := 0
+	// Step 2: Plan the transaction fetch for every account within the request budget,
+	// using cached transactions where they are fresh enough
+	var fetchErrors []AccountFetchError
+	var windows []fetchWindow
 	for i := range sfResp.Accounts {
 		account := &sfResp.Accounts[i]
 
 		// Check if we should skip transactions for this account
-		if accConfig, mapped := config.AccountMap[account.ID]; mapped && accConfig.BalanceOnly {
+		accConfig, mapped := config.AccountMap[account.ID]
+		if !mapped && !opts.AutoCreate {
+			continue // Not synced, no requests spent on it
+		}
+		if accConfig.BalanceOnly {
 			log.Printf("Skipping transaction fetch for account %s (balance_only is set)", account.Name)
 			continue
 		}
+		if accConfig.Closed {
+			log.Printf("Skipping transaction fetch for account %s (closed)", account.Name)
+			continue
+		}
 
 		// Determine date range for transaction fetch
-		totalStartDate, totalEndDate := getTransactionDateRange(account.ID, accountSyncState)
-
-		log.Printf("Fetching transactions for account %s (%s) from %d to %d...", account.Name, account.ID, totalStartDate, totalEndDate)
-
-		// SimpleFIN API limit: Difference between start and end date must not exceed 90 days.
-		// Page through the total date range in increments of maxRangeSeconds (90 days).
-		currentStartDate := totalStartDate
-		for currentStartDate < totalEndDate {
-			currentEndDate := currentStartDate + maxRangeSeconds
-			if currentEndDate > totalEndDate {
-				currentEndDate = totalEndDate
-			}
-
-			log.Printf("  → Fetching page: %d to %d", currentStartDate, currentEndDate)
-
-			// Build URL with account ID and date parameters
-			txURL := fmt.Sprintf("%s/accounts?account=%s", accessURL, account.ID)
-			if currentStartDate != 0 {
-				txURL += fmt.Sprintf("&start-date=%d", currentStartDate)
-			}
-			if currentEndDate != 0 {
-				txURL += fmt.Sprintf("&end-date=%d", currentEndDate)
-			}
+		pendingEnabled := config.PendingEnabled(account.ID)
+		var pendingSince int64
+		if pendingEnabled {
+			pendingSince = oldestPendingDate(ledger, account.ID)
+		}
+		startDate, endDate := getTransactionDateRange(account.ID, accountSyncState, pendingSince, config.Lookback())
+		startDate, endDate = accConfig.clampWindow(startDate, endDate)
+		if endDate <= startDate {
+			log.Printf("Skipping transaction fetch for account %s (outside start_date/end_date)", account.Name)
+			continue
+		}
 
-			txResp, err := http.Get(txURL)
-			if err != nil || txResp.StatusCode != 200 {
-				if txResp != nil {
-					txBodyBytes, _ := io.ReadAll(txResp.Body)
-					txResp.Body.Close()
-					log.Printf("Warning: Failed to fetch transactions for account %s. Status: %v Body: %s Error: %v", account.ID, txResp.StatusCode, string(txBodyBytes), err)
-				} else {
-					log.Printf("Warning: Failed to fetch transactions for account %s: %v", account.ID, err)
-				}
-				log.Fatalf("failed to get trxs\n")
+		cached, haveCached := LoadCachedAccount(account.ID)
+		if opts.Offline && !haveCached {
+			log.Printf("%sWarning: No cached transactions for %s%s", colorRed, account.Name, colorReset)
+			fetchErrors = append(fetchErrors, AccountFetchError{AccountID: account.ID, AccountName: account.Name, Err: errors.New("offline mode: not in cache")})
+			continue
+		}
+		if opts.Offline || (haveCached && cached.Fresh(ttl) && cached.StartDate <= startDate) {
+			log.Printf("Using cached transactions for %s from %s (%d transactions)", account.Name, cached.FetchedAt.Format(time.RFC3339), len(cached.Account.Transactions))
+			account.Transactions = cached.Account.Transactions
+			account.SyncedThrough = cached.EndDate
+			if len(cached.Account.Holdings) > 0 {
+				account.Holdings = cached.Account.Holdings
 			}
+			continue
+		}
 
-			txBodyBytes, _ := io.ReadAll(txResp.Body)
-			txResp.Body.Close()
+		windows = append(windows, fetchWindow{account: account, start: startDate, end: endDate, pending: pendingEnabled})
+	}
 
-			var accountResp SimpleFINResponse
-			if err := json.Unmarshal(txBodyBytes, &accountResp); err != nil {
-				log.Printf("Warning: Failed to decode transactions for account %s: %v\nResponse body: %s", account.ID, err, string(txBodyBytes))
-				break // Break the paging loop for this account on decode error
+	plan := planFetch(windows, quota.Remaining())
+	quota.Reserve(plan.requests)
+	log.Printf("SimpleFIN requests: %d made today, %d planned for transactions", quota.Requests, plan.requests)
+
+	// Step 3: Fetch transactions in 90-day increments, either for all accounts at once or one account at a time
+	failed := make(map[string]error)
+	if plan.combined {
+		log.Printf("Fetching transactions for %d accounts together...", len(plan.windows))
+		if err := fetcher.fetchCombinedTransactions(log.Default(), plan.windows); err != nil {
+			for _, w := range plan.windows {
+				failed[w.account.ID] = err
 			}
-
-			// Print any errors from SimpleFIN for this account
-			printSimpleFINErrors(accountResp.Errors)
-
-			// Extract transactions from the response and append to the account's transaction list
-			if len(accountResp.Accounts) > 0 {
-				account.Transactions = append(account.Transactions, accountResp.Accounts[0].Transactions...)
-				log.Printf("    → Pulled %d transactions in this page", len(accountResp.Accounts[0].Transactions))
+		}
+	} else {
+		for i, err := range fetcher.fetchAccountsConcurrently(plan.windows) {
+			if err != nil {
+				failed[plan.windows[i].account.ID] = err
 			}
+		}
+	}
 
-			// Move to the next page, starting exactly where we left off to avoid missing any transactions
-			currentStartDate = currentEndDate
+	totalTransactions := 0
+	for _, w := range plan.windows {
+		account := w.account
+		if err, ok := failed[account.ID]; ok {
+			log.Printf("%sWarning: Failed to fetch transactions for %s: %v%s", colorRed, account.Name, err, colorReset)
+			fetchErrors = append(fetchErrors, AccountFetchError{AccountID: account.ID, AccountName: account.Name, Err: err})
+			continue
 		}
 
 		if len(account.Transactions) > 0 {
@@ -182,47 +267,261 @@ func FetchSimpleFINData(accessURL string, forceRefresh bool, config Config) Simp
 			log.Printf("  → No transactions found for %s", account.Name)
 		}
 
-		// Update sync state for this account to the end of the total range we just processed
-		accountSyncState[account.ID] = AccountSyncState{
-			LastSyncDate: totalEndDate,
-		}
+		account.SyncedThrough = w.end
 
 		// Cache the account data
-		cached := CachedAccount{
-			Account:   *account,
-			FetchedAt: time.Now(),
+		if err := SaveCachedAccount(*account, w.start, w.end); err != nil {
+			log.Printf("Warning: Failed to cache account %s: %v", account.Name, err)
 		}
-		data, _ := json.MarshalIndent(cached, "", "  ")
-		os.WriteFile(cacheDir+"/account_"+account.ID+".json", data, 0644)
 	}
 
-	// Save updated sync state
-	SaveAccountSyncState(accountSyncState)
+	for _, w := range plan.deferred {
+		log.Printf("%sDeferring transaction fetch for %s to the next run (request budget)%s", colorRed, w.account.Name, colorReset)
+		fetchErrors = append(fetchErrors, AccountFetchError{AccountID: w.account.ID, AccountName: w.account.Name, Err: ErrDeferred})
+	}
 
 	log.Printf("Total transactions pulled: %d\n", totalTransactions)
-	return sfResp
+	return sfResp, fetchErrors, nil
+}
+
+// simplefinFetcher issues requests against a SimpleFIN access URL, counting each
+// one against the daily request quota
+type simplefinFetcher struct {
+	accessURL string
+	quota     *RequestQuota
+	workers   int // Requests allowed in flight at once
+}
+
+// fetchAccountsConcurrently fetches each window's account with at most f.workers
+// accounts in flight. Every account only touches its own SFAccount, and its log lines
+// are buffered and written in window order once all fetches are done, so the output
+// is the same as a sequential run. The returned errors are indexed like windows.
+func (f *simplefinFetcher) fetchAccountsConcurrently(windows []fetchWindow) []error {
+	errs := make([]error, len(windows))
+	logs := make([]bytes.Buffer, len(windows))
+
+	runBounded(len(windows), f.workers, func(i int) {
+		w := windows[i]
+		logger := log.New(&logs[i], log.Prefix(), log.Flags())
+		logger.Printf("Fetching transactions for account %s (%s) from %d to %d...", w.account.Name, w.account.ID, w.start, w.end)
+		errs[i] = f.fetchAccountTransactions(logger, w.account, w.start, w.end, w.pending)
+	})
+
+	for i := range logs {
+		log.Writer().Write(logs[i].Bytes())
+	}
+	return errs
+}
+
+// fetchAccountTransactions appends the account's transactions between startDate and
+// endDate. SimpleFIN API limit: Difference between start and end date must not exceed
+// 90 days, so the range is paged through in increments of maxRangeSeconds.
+func (f *simplefinFetcher) fetchAccountTransactions(logger *log.Logger, account *SFAccount, startDate, endDate int64, pending bool) error {
+	return f.fetchPages(logger, startDate, endDate, "account="+account.ID, pending, 1, func(resp SimpleFINResponse) {
+		// Extract transactions from the response and append to the account's transaction list
+		if len(resp.Accounts) > 0 {
+			mergeFetchedAccount(account, resp.Accounts[0])
+			logger.Printf("    → Pulled %d transactions in this page", len(resp.Accounts[0].Transactions))
+		}
+	})
+}
+
+// fetchCombinedTransactions fetches the transactions of several accounts with one
+// request per 90-day page covering all of their windows
+func (f *simplefinFetcher) fetchCombinedTransactions(logger *log.Logger, windows []fetchWindow) error {
+	byID := make(map[string]*SFAccount, len(windows))
+	startDate, endDate := windows[0].start, windows[0].end
+	pending := false
+	for _, w := range windows {
+		byID[w.account.ID] = w.account
+		startDate = min(startDate, w.start)
+		endDate = max(endDate, w.end)
+		pending = pending || w.pending
+	}
+
+	return f.fetchPages(logger, startDate, endDate, "", pending, f.workers, func(resp SimpleFINResponse) {
+		pulled := 0
+		for _, fetched := range resp.Accounts {
+			if account, ok := byID[fetched.ID]; ok {
+				mergeFetchedAccount(account, fetched)
+				pulled += len(fetched.Transactions)
+			}
+		}
+		logger.Printf("    → Pulled %d transactions in this page", pulled)
+	})
+}
+
+// fetchPages requests the accounts endpoint for each 90-day page between startDate
+// and endDate, with up to workers pages in flight. Responses are passed to handle,
+// and the errors SimpleFIN reports in them written to logger, in page order.
+func (f *simplefinFetcher) fetchPages(logger *log.Logger, startDate, endDate int64, query string, pending bool, workers int, handle func(SimpleFINResponse)) error {
+	// Split the range into pages, each starting exactly where the previous one ended to avoid missing any transactions
+	type page struct{ start, end int64 }
+	var pages []page
+	for currentStartDate := startDate; currentStartDate < endDate; currentStartDate += maxRangeSeconds {
+		pages = append(pages, page{start: currentStartDate, end: min(currentStartDate+maxRangeSeconds, endDate)})
+	}
+
+	responses := make([]SimpleFINResponse, len(pages))
+	errs := make([]error, len(pages))
+	runBounded(len(pages), workers, func(i int) {
+		p := pages[i]
+
+		// Build URL with date parameters
+		params := []string{}
+		if query != "" {
+			params = append(params, query)
+		}
+		if p.start != 0 {
+			params = append(params, fmt.Sprintf("start-date=%d", p.start))
+		}
+		if p.end != 0 {
+			params = append(params, fmt.Sprintf("end-date=%d", p.end))
+		}
+		if pending {
+			params = append(params, "pending=1")
+		}
+
+		responses[i], errs[i] = f.fetchAccounts(f.accessURL + "/accounts?" + strings.Join(params, "&"))
+	})
+
+	for i, p := range pages {
+		logger.Printf("  → Fetched page: %d to %d", p.start, p.end)
+		if errs[i] != nil {
+			return fmt.Errorf("page %d to %d: %w", p.start, p.end, errs[i])
+		}
+		printSimpleFINErrors(logger.Writer(), responses[i].Errors)
+		handle(responses[i])
+	}
+	return nil
+}
+
+// mergeFetchedAccount adds the transactions and latest holdings of a fetched page to
+// account, and fills in the account details it does not have yet
+func mergeFetchedAccount(account *SFAccount, fetched SFAccount) {
+	if account.Name == "" {
+		account.Name = fetched.Name
+	}
+	if account.Org.Domain == "" && account.Org.SfinURL == "" {
+		account.Org = fetched.Org
+	}
+	if account.Currency == "" {
+		account.Currency = fetched.Currency
+	}
+	if account.Extra == nil {
+		account.Extra = fetched.Extra
+	}
+	account.Transactions = append(account.Transactions, fetched.Transactions...)
+	if len(fetched.Holdings) > 0 {
+		account.Holdings = fetched.Holdings
+	}
+}
+
+// fetchAccounts performs a GET against the SimpleFIN accounts endpoint and decodes
+// the response. Errors SimpleFIN reports are left in it for the caller to print, so
+// that they appear next to the account they concern.
+func (f *simplefinFetcher) fetchAccounts(url string) (SimpleFINResponse, error) {
+	var sfResp SimpleFINResponse
+
+	resp, err := simplefinClient.GetCounted(url, f.quota.Attempt)
+	if err != nil {
+		return sfResp, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return sfResp, fmt.Errorf("status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	if err := json.Unmarshal(bodyBytes, &sfResp); err != nil {
+		return sfResp, fmt.Errorf("decode response: %w\nResponse body: %s", err, string(bodyBytes))
+	}
+	return sfResp, nil
 }
 
-// getTransactionDateRange determines the start and end dates for fetching transactions
-func getTransactionDateRange(accountID string, syncState map[string]AccountSyncState) (int64, int64) {
+// getTransactionDateRange determines the start and end dates for fetching transactions.
+// The start overlaps the last sync by lookback so late-posting transactions are caught;
+// the ledger skips those already imported. A non-zero pendingSince moves the start back
+// so outstanding pending transactions are re-fetched.
+func getTransactionDateRange(accountID string, syncState map[string]AccountSyncState, pendingSince int64, lookback time.Duration) (int64, int64) {
 	endDate := time.Now().Unix()
 
+	// No previous sync - go back one year
+	startDate := time.Now().AddDate(-1, 0, 0).Unix()
+
 	// Check if we have a last sync date for this account
 	if state, exists := syncState[accountID]; exists && state.LastSyncDate != 0 {
-		// Use last sync date as start date to get only new transactions
-		return state.LastSyncDate, endDate
+		// Start from the last sync date to get only new transactions, plus the overlap
+		startDate = state.LastSyncDate - int64(lookback.Seconds())
 	}
 
-	// No previous sync - go back one year
-	startDate := time.Now().AddDate(-1, 0, 0).Unix()
+	if pendingSince != 0 && pendingSince < startDate {
+		startDate = pendingSince
+	}
 	return startDate, endDate
 }
 
-// printSimpleFINErrors prints SimpleFIN errors in red color
-func printSimpleFINErrors(errors []string) {
-	if len(errors) > 0 {
-		for _, errMsg := range errors {
-			fmt.Printf("%s⚠️  SimpleFIN Error: %s%s\n", colorRed, errMsg, colorReset)
+// oldestPendingDate returns the date of the oldest pending transaction imported for an account, or 0
+func oldestPendingDate(ledger *Ledger, accountID string) int64 {
+	entries, err := ledger.PendingEntries(accountID)
+	if err != nil {
+		log.Printf("Warning: Failed to read pending transactions for account %s: %v", accountID, err)
+		return 0
+	}
+
+	var oldest int64
+	for _, entry := range entries {
+		date, err := time.Parse("2006-01-02", entry.Date)
+		if err != nil {
+			continue
+		}
+		if ts := date.Unix(); oldest == 0 || ts < oldest {
+			oldest = ts
 		}
 	}
+	return oldest
+}
+
+// customCurrencies caches resolved custom currency URLs for the duration of a run
+var customCurrencies = make(map[string]string)
+
+// ResolveCurrency returns the currency code for a SimpleFIN account currency.
+// SimpleFIN reports either an ISO 4217 code or a URL describing a custom currency,
+// in which case its abbreviation is fetched.
+func ResolveCurrency(currency string) string {
+	if currency == "" {
+		return defaultCurrency
+	}
+	if !strings.HasPrefix(currency, "http://") && !strings.HasPrefix(currency, "https://") {
+		return strings.ToUpper(currency)
+	}
+	if code, ok := customCurrencies[currency]; ok {
+		return code
+	}
+
+	code := defaultCurrency
+	resp, err := simplefinClient.Get(currency)
+	if err == nil && resp.StatusCode == 200 {
+		var custom SFCustomCurrency
+		if err := json.NewDecoder(resp.Body).Decode(&custom); err == nil && custom.Abbr != "" {
+			code = custom.Abbr
+		} else {
+			log.Printf("Warning: Custom currency %s has no abbreviation, using %s", currency, defaultCurrency)
+		}
+	} else {
+		log.Printf("Warning: Failed to fetch custom currency %s, using %s", currency, defaultCurrency)
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	customCurrencies[currency] = code
+	return code
+}
+
+// printSimpleFINErrors writes SimpleFIN errors to w in red color
+func printSimpleFINErrors(w io.Writer, errors []string) {
+	for _, errMsg := range errors {
+		fmt.Fprintf(w, "%s⚠️  SimpleFIN Error: %s%s\n", colorRed, errMsg, colorReset)
+	}
 }
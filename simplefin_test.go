@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchSimpleFINDataSkipsUnmappedAccounts(t *testing.T) {
+	for _, autoCreate := range []bool{false, true} {
+		t.Chdir(t.TempDir()) // The cache and request count are written to the working directory
+
+		var mu sync.Mutex
+		fetched := make(map[string]int)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if q.Get("balances-only") == "1" {
+				fmt.Fprint(w, `{"errors":[],"accounts":[{"id":"mapped","name":"Checking","balance":"1.00"},{"id":"other","name":"Other","balance":"2.00"}]}`)
+				return
+			}
+			mu.Lock()
+			accounts := q["account"]
+			if len(accounts) == 0 {
+				accounts = []string{"mapped", "other"} // Fetched together
+			}
+			for _, id := range accounts {
+				fetched[id]++
+			}
+			mu.Unlock()
+			fmt.Fprint(w, `{"errors":[],"accounts":[]}`)
+		}))
+
+		config := Config{AccountMap: map[string]AccountConfig{"mapped": {SureID: "s-1", Name: "Checking"}}}
+		_, fetchErrors, err := FetchSimpleFINData(server.URL, FetchOptions{AutoCreate: autoCreate}, config, openTestLedger(t), map[string]AccountSyncState{})
+		server.Close()
+		if err != nil || len(fetchErrors) != 0 {
+			t.Fatalf("autoCreate=%v: err = %v, fetch errors = %v", autoCreate, err, fetchErrors)
+		}
+		if fetched["mapped"] == 0 {
+			t.Errorf("autoCreate=%v: mapped account not fetched: %v", autoCreate, fetched)
+		}
+		if got := fetched["other"] > 0; got != autoCreate {
+			t.Errorf("autoCreate=%v: unmapped account fetched = %v, want %v", autoCreate, got, autoCreate)
+		}
+	}
+}
+
+func TestFetchAccountsConcurrentlyKeepsErrorsWithTheirAccount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("account")
+		if id == "a" {
+			time.Sleep(50 * time.Millisecond) // Finishes after b
+		}
+		fmt.Fprintf(w, `{"errors":["%s: connection needs attention"],"accounts":[{"id":"%s","transactions":[]}]}`, id, id)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	f := &simplefinFetcher{accessURL: server.URL, quota: &RequestQuota{budget: -1}, workers: 2}
+	now := time.Now().Unix()
+	windows := []fetchWindow{
+		{account: &SFAccount{ID: "a", Name: "Alpha"}, start: now - 3600, end: now},
+		{account: &SFAccount{ID: "b", Name: "Beta"}, start: now - 3600, end: now},
+	}
+	for _, err := range f.fetchAccountsConcurrently(windows) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logged := out.String()
+	order := []string{"account Alpha", "a: connection needs attention", "account Beta", "b: connection needs attention"}
+	last := -1
+	for _, s := range order {
+		i := strings.Index(logged, s)
+		if i <= last {
+			t.Fatalf("%q out of order in:\n%s", s, logged)
+		}
+		last = i
+	}
+}
@@ -16,13 +16,91 @@ import (
 
 // SureTransaction represents a transaction in Sure
 type SureTransaction struct {
+	AccountID  string   `json:"account_id"`
+	Amount     string   `json:"amount"`
+	Date       string   `json:"date"`
+	Name       string   `json:"name"`
+	Notes      string   `json:"notes"`
+	CategoryID string   `json:"category_id,omitempty"`
+	TagIDs     []string `json:"tag_ids,omitempty"`
+	MerchantID string   `json:"merchant_id,omitempty"`
+}
+
+// SureTransactionUpdate holds the fields of an imported Sure transaction to change.
+// Empty fields are left as they are in Sure.
+type SureTransactionUpdate struct {
+	Amount string `json:"amount,omitempty"`
+	Date   string `json:"date,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Notes  string `json:"notes,omitempty"`
+}
+
+// SureTransfer represents a transfer between two Sure accounts, recorded as a linked
+// outflow and inflow transaction
+type SureTransfer struct {
+	FromAccountID string `json:"from_account_id"`
+	ToAccountID   string `json:"to_account_id"`
+	Amount        string `json:"amount"` // Positive amount moved
+	Date          string `json:"date"`
+	Notes         string `json:"notes,omitempty"`
+}
+
+// SureTransferResponse identifies a created transfer and its two transactions
+type SureTransferResponse struct {
+	ID                   string
+	OutflowTransactionID string
+	InflowTransactionID  string
+}
+
+// SureAPIError is returned when Sure answers a request with an error status.
+// Unlike network errors it means the request was definitely not applied.
+type SureAPIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *SureAPIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Body)
+}
+
+// SureTransactionsResponse represents the response from the Sure transactions endpoint
+type SureTransactionsResponse struct {
+	Transactions []SureTransactionResponse `json:"transactions"`
+}
+
+// SureTransactionResponse represents a transaction as returned by the Sure API
+type SureTransactionResponse struct {
+	ID             string `json:"id"`
+	Date           string `json:"date"`
+	Amount         string `json:"amount"`
+	Currency       string `json:"currency"`
+	Name           string `json:"name"`
+	Notes          string `json:"notes"`
+	Classification string `json:"classification"`
+	Transfer       *struct {
+		ID string `json:"id"`
+	} `json:"transfer,omitempty"` // Set when the transaction is a side of a transfer
+}
+
+// SureValuation represents a dated account balance in Sure
+type SureValuation struct {
 	AccountID string `json:"account_id"`
 	Amount    string `json:"amount"`
 	Date      string `json:"date"`
-	Name      string `json:"name"`
 	Notes     string `json:"notes"`
 }
 
+// SureTrade represents a buy or sell of a security in a Sure investment account
+type SureTrade struct {
+	AccountID string `json:"account_id"`
+	Date      string `json:"date"`
+	Ticker    string `json:"ticker"`
+	Qty       string `json:"qty"`
+	Price     string `json:"price"`
+	Currency  string `json:"currency"`
+	Type      string `json:"type"` // "buy" or "sell"
+}
+
 // SureAccount represents an account in Sure
 type SureAccount struct {
 	ID             string `json:"id"`
@@ -56,7 +134,7 @@ func FetchSureAccounts(baseURL, apiKey string) ([]SureAccount, error) {
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Set("X-Api-Key", apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sureClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -64,7 +142,7 @@ func FetchSureAccounts(baseURL, apiKey string) ([]SureAccount, error) {
 
 	if resp.StatusCode != 200 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, &SureAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
 	}
 
 	var result SureAccountsResponse
@@ -74,8 +152,77 @@ func FetchSureAccounts(baseURL, apiKey string) ([]SureAccount, error) {
 	return result.Accounts, nil
 }
 
-// CreateSureTransaction creates a new transaction in Sure
-func CreateSureTransaction(baseURL, apiKey string, tx SureTransaction) error {
+// FetchSureNames retrieves every record of a named Sure resource (e.g. "categories",
+// "tags", "merchants") and indexes their IDs by lowercased name, following pagination
+func FetchSureNames(baseURL, apiKey, resource string) (map[string]string, error) {
+	names := make(map[string]string)
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/%s?page=%d&per_page=100", baseURL, resource, page)
+
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("X-Api-Key", apiKey)
+
+		resp, err := sureClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return nil, &SureAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+		}
+
+		// Records are either wrapped in a key named after the resource or a bare array
+		records := gjson.GetBytes(bodyBytes, resource)
+		if !records.Exists() {
+			records = gjson.ParseBytes(bodyBytes)
+		}
+		for _, record := range records.Array() {
+			names[strings.ToLower(record.Get("name").String())] = record.Get("id").String()
+		}
+
+		if page >= int(gjson.GetBytes(bodyBytes, "pagination.total_pages").Int()) {
+			return names, nil
+		}
+	}
+}
+
+// FetchSureTransactions retrieves the transactions of a Sure account dated between
+// the two days, inclusive, following pagination
+func FetchSureTransactions(baseURL, apiKey, accountID, startDate, endDate string) ([]SureTransactionResponse, error) {
+	var transactions []SureTransactionResponse
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/transactions?account_id=%s&start_date=%s&end_date=%s&page=%d&per_page=100", baseURL, accountID, startDate, endDate, page)
+
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("X-Api-Key", apiKey)
+
+		resp, err := sureClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return nil, &SureAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+		}
+
+		var result SureTransactionsResponse
+		if err := json.Unmarshal(bodyBytes, &result); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, result.Transactions...)
+
+		if page >= int(gjson.GetBytes(bodyBytes, "pagination.total_pages").Int()) {
+			return transactions, nil
+		}
+	}
+}
+
+// CreateSureTransaction creates a new transaction in Sure and returns it as created.
+// A successful response that cannot be decoded is logged rather than returned as an
+// error, since the transaction already exists in Sure.
+func CreateSureTransaction(baseURL, apiKey string, tx SureTransaction) (SureTransactionResponse, error) {
 	url := fmt.Sprintf("%s/transactions", baseURL)
 
 	// Wrap in a "transaction" key as standard in Rails APIs
@@ -86,7 +233,86 @@ func CreateSureTransaction(baseURL, apiKey string, tx SureTransaction) error {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Api-Key", apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	var created SureTransactionResponse
+	resp, err := sureClient.Do(req)
+	if err != nil {
+		return created, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return created, &SureAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+
+	if err := json.Unmarshal(bodyBytes, &created); err != nil || created.ID == "" {
+		log.Printf("Warning: Could not read created transaction ID from Sure response: %s", string(bodyBytes))
+	}
+	return created, nil
+}
+
+// UpdateSureTransaction changes the given fields of an existing Sure transaction
+func UpdateSureTransaction(baseURL, apiKey, id string, tx SureTransactionUpdate) (SureTransactionResponse, error) {
+	url := fmt.Sprintf("%s/transactions/%s", baseURL, id)
+
+	payload := map[string]interface{}{"transaction": tx}
+	jsonValue, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("PUT", url, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", apiKey)
+
+	var updated SureTransactionResponse
+	resp, err := sureClient.Do(req)
+	if err != nil {
+		return updated, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return updated, &SureAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+	json.Unmarshal(bodyBytes, &updated)
+	return updated, nil
+}
+
+// DeleteSureTransaction deletes a transaction from Sure
+func DeleteSureTransaction(baseURL, apiKey, id string) error {
+	url := fmt.Sprintf("%s/transactions/%s", baseURL, id)
+
+	req, _ := http.NewRequest("DELETE", url, nil)
+	req.Header.Set("X-Api-Key", apiKey)
+
+	resp, err := sureClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return &SureAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+	return nil
+}
+
+// CreateSureValuation records an account balance in Sure as of the given date
+func CreateSureValuation(baseURL, apiKey string, valuation SureValuation) error {
+	if dryRun != nil {
+		dryRun.record(PlannedWrite{Action: "create_valuation", Valuation: &valuation})
+		return nil
+	}
+	url := fmt.Sprintf("%s/valuations", baseURL)
+
+	payload := map[string]interface{}{"valuation": valuation}
+	jsonValue, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", apiKey)
+
+	resp, err := sureClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -94,21 +320,79 @@ func CreateSureTransaction(baseURL, apiKey string, tx SureTransaction) error {
 
 	if resp.StatusCode >= 300 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(bodyBytes))
+		return &SureAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
 	}
 	return nil
 }
 
-// PromptAndCreateSureAccount prompts the user to create a new Sure account
-func PromptAndCreateSureAccount(baseURL, apiKey string, sfAcc SFAccount) (string, error) {
+// CreateSureTransfer creates a linked transfer between two Sure accounts
+func CreateSureTransfer(baseURL, apiKey string, transfer SureTransfer) (SureTransferResponse, error) {
+	var created SureTransferResponse
+	url := fmt.Sprintf("%s/transfers", baseURL)
+
+	payload := map[string]interface{}{"transfer": transfer}
+	jsonValue, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", apiKey)
+
+	resp, err := sureClient.Do(req)
+	if err != nil {
+		return created, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return created, &SureAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+	created.ID = gjson.GetBytes(bodyBytes, "id").String()
+	created.OutflowTransactionID = gjson.GetBytes(bodyBytes, "outflow_transaction.id").String()
+	created.InflowTransactionID = gjson.GetBytes(bodyBytes, "inflow_transaction.id").String()
+	return created, nil
+}
+
+// CreateSureTrade records a trade in a Sure investment account
+func CreateSureTrade(baseURL, apiKey string, trade SureTrade) error {
+	if dryRun != nil {
+		dryRun.record(PlannedWrite{Action: "create_trade", Trade: &trade})
+		return nil
+	}
+	url := fmt.Sprintf("%s/trades", baseURL)
+
+	payload := map[string]interface{}{"trade": trade}
+	jsonValue, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", apiKey)
+
+	resp, err := sureClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return &SureAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+	return nil
+}
+
+// PromptAndCreateSureAccount prompts the user to create a new Sure account.
+// It returns the new account ID and the chosen accountable type.
+func PromptAndCreateSureAccount(baseURL, apiKey string, sfAcc SFAccount) (string, string, error) {
 	reader := bufio.NewReader(os.Stdin)
+	out := reportOutput()
 
-	fmt.Printf("\nUnmapped SimpleFIN account found:\n")
-	fmt.Printf("  Name: %s\n", sfAcc.Name)
-	fmt.Printf("  Org:  %s\n", sfAcc.Org.Domain)
+	fmt.Fprintf(out, "\nUnmapped SimpleFIN account found:\n")
+	fmt.Fprintf(out, "  Name: %s\n", sfAcc.Name)
+	fmt.Fprintf(out, "  Org:  %s\n", sfAcc.Org.Domain)
 
 	defaultName := fmt.Sprintf("%s %s", sfAcc.Name, sfAcc.Org.Domain)
-	fmt.Printf("Enter Sure account name [%s]: ", defaultName)
+	fmt.Fprintf(out, "Enter Sure account name [%s]: ", defaultName)
 	name, _ := reader.ReadString('\n')
 	name = strings.TrimSpace(name)
 	if name == "" {
@@ -131,27 +415,33 @@ func PromptAndCreateSureAccount(baseURL, apiKey string, sfAcc SFAccount) (string
 		{"OtherLiability", "Other Liability (Liabilities) - Other debts"},
 	}
 
-	fmt.Println("\nSelect AccountableType:")
+	fmt.Fprintln(out, "\nSelect AccountableType:")
 	for i, at := range accountableTypes {
-		fmt.Printf("  %d. %s\n", i+1, at.label)
+		fmt.Fprintf(out, "  %d. %s\n", i+1, at.label)
 	}
 
 	var accountableType string
 	for {
-		fmt.Print("Enter selection (1-9): ")
+		fmt.Fprint(out, "Enter selection (1-9): ")
 		input, _ := reader.ReadString('\n')
 		input = strings.TrimSpace(input)
 		if idx := parseInt(input); idx >= 1 && idx <= len(accountableTypes) {
 			accountableType = accountableTypes[idx-1].value
 			break
 		}
-		fmt.Println("Invalid selection. Please try again.")
+		fmt.Fprintln(out, "Invalid selection. Please try again.")
 	}
 
 	// SubType picker based on AccountableType
 	subtype := promptSubtype(reader, accountableType)
 
-	return createSureAccount(baseURL, apiKey, name, accountableType, subtype)
+	payload := newCreateSureAccountRequest(name, accountableType, subtype, ResolveCurrency(sfAcc.Currency))
+	if dryRun != nil {
+		dryRun.record(PlannedWrite{Action: "create_account", Account: &payload})
+		return "new:" + sfAcc.ID, accountableType, nil
+	}
+	id, err := createSureAccount(baseURL, apiKey, payload)
+	return id, accountableType, err
 }
 
 // promptSubtype prompts the user to select a subtype based on the accountable type
@@ -160,20 +450,21 @@ func promptSubtype(reader *bufio.Reader, accountableType string) string {
 	if len(subtypes) == 0 {
 		return "" // No subtypes for this accountableType
 	}
+	out := reportOutput()
 
-	fmt.Printf("\nSelect SubType for %s:\n", accountableType)
+	fmt.Fprintf(out, "\nSelect SubType for %s:\n", accountableType)
 	for i, st := range subtypes {
-		fmt.Printf("  %d. %s\n", i+1, st.label)
+		fmt.Fprintf(out, "  %d. %s\n", i+1, st.label)
 	}
 
 	for {
-		fmt.Printf("Enter selection (1-%d): ", len(subtypes))
+		fmt.Fprintf(out, "Enter selection (1-%d): ", len(subtypes))
 		input, _ := reader.ReadString('\n')
 		input = strings.TrimSpace(input)
 		if idx := parseInt(input); idx >= 1 && idx <= len(subtypes) {
 			return subtypes[idx-1].value
 		}
-		fmt.Println("Invalid selection. Please try again.")
+		fmt.Fprintln(out, "Invalid selection. Please try again.")
 	}
 }
 
@@ -276,16 +567,20 @@ func getSubtypes(accountableType string) []struct {
 	}
 }
 
-// createSureAccount creates a new account in Sure
-func createSureAccount(baseURL, apiKey, name, category, subtype string) (string, error) {
-	url := fmt.Sprintf("%s/accounts", baseURL)
-
+// newCreateSureAccountRequest builds the payload for a new Sure account with a zero opening balance
+func newCreateSureAccountRequest(name, category, subtype, currency string) CreateSureAccountRequest {
 	var payload CreateSureAccountRequest
 	payload.Account.Name = name
 	payload.Account.AccountableType = category
-	payload.Account.Currency = "USD" // Defaulting to USD
+	payload.Account.Currency = currency
 	payload.Account.SubType = subtype
 	payload.Account.Balance = 0.0 // Defaulting to 0.0
+	return payload
+}
+
+// createSureAccount creates a new account in Sure
+func createSureAccount(baseURL, apiKey string, payload CreateSureAccountRequest) (string, error) {
+	url := fmt.Sprintf("%s/accounts", baseURL)
 
 	jsonValue, _ := json.Marshal(payload)
 
@@ -293,7 +588,7 @@ func createSureAccount(baseURL, apiKey, name, category, subtype string) (string,
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Api-Key", apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sureClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -301,7 +596,7 @@ func createSureAccount(baseURL, apiKey, name, category, subtype string) (string,
 
 	if resp.StatusCode >= 300 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API error %d: %s", resp.StatusCode, string(bodyBytes))
+		return "", &SureAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
 	}
 
 	bodyBytes, _ := io.ReadAll(resp.Body)
@@ -12,11 +12,23 @@ const (
 
 // AccountSyncState tracks the last sync date for an account
 type AccountSyncState struct {
-	LastSyncDate int64 `json:"last_sync_date"` // Unix timestamp
+	LastSyncDate    int64  `json:"last_sync_date"`              // Unix timestamp
+	LastBalance     string `json:"last_balance,omitempty"`      // Last balance pushed to Sure
+	LastBalanceDate uint64 `json:"last_balance_date,omitempty"` // SimpleFIN balance-date of LastBalance
+
+	Holdings map[string]HoldingSnapshot `json:"holdings,omitempty"` // Positions as of the last holdings sync, keyed by holding ID
+}
+
+// HoldingSnapshot is the last synced state of an investment position
+type HoldingSnapshot struct {
+	Symbol      string `json:"symbol"`
+	Shares      string `json:"shares"`
+	MarketValue string `json:"market_value"`
+	CostBasis   string `json:"cost_basis"`
 }
 
-// LoadState loads the transaction sync state from disk
-// Maps transaction ID -> processed status
+// LoadState loads the legacy transaction sync state from disk
+// Maps transaction ID -> processed status. Only used to migrate into the Ledger.
 func LoadState() map[string]bool {
 	state := make(map[string]bool)
 	file, err := os.ReadFile(stateFile)
@@ -26,15 +38,6 @@ func LoadState() map[string]bool {
 	return state
 }
 
-// SaveState saves the transaction sync state to disk
-func SaveState(state map[string]bool) error {
-	data, err := json.MarshalIndent(state, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(stateFile, data, 0644)
-}
-
 // LoadAccountSyncState loads the account sync state from disk
 // Maps account ID -> sync state
 func LoadAccountSyncState() map[string]AccountSyncState {
@@ -54,3 +57,14 @@ func SaveAccountSyncState(state map[string]AccountSyncState) error {
 	}
 	return os.WriteFile(accountSyncStateFile, data, 0644)
 }
+
+// advanceSyncDate moves the account's LastSyncDate to the end of the window fetched
+// this run. Call it only once every transaction in that window has been imported.
+func advanceSyncDate(syncState map[string]AccountSyncState, account SFAccount) {
+	if account.SyncedThrough == 0 {
+		return
+	}
+	state := syncState[account.ID]
+	state.LastSyncDate = max(state.LastSyncDate, account.SyncedThrough)
+	syncState[account.ID] = state
+}
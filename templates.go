@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TransactionTemplateData is what name_template and notes_template are executed with
+type TransactionTemplateData struct {
+	ID           string
+	Amount       string // As imported, after invert_amounts
+	Description  string
+	Payee        string
+	Memo         string
+	Pending      bool
+	Posted       time.Time // Zero while pending
+	TransactedAt time.Time
+	Date         string // YYYY-MM-DD date given to the Sure transaction
+	Name         string // Normalized payee or description; in notes_template, the name given to the transaction
+	Extra        map[string]interface{}
+	Account      TemplateAccount
+}
+
+// TemplateAccount describes the account of a templated transaction
+type TemplateAccount struct {
+	ID         string // SimpleFIN account ID
+	Name       string // Name reported by SimpleFIN
+	MappedName string // Name in account_map
+	Currency   string
+	OrgDomain  string
+	Extra      map[string]interface{}
+}
+
+// sampleTemplateData is used to check templates when the config is loaded
+var sampleTemplateData = TransactionTemplateData{
+	ID:           "TRN-sample",
+	Amount:       "-12.34",
+	Description:  "POS DEBIT SAMPLE STORE #123",
+	Payee:        "Sample Store",
+	Memo:         "Sample memo",
+	Posted:       time.Unix(0, 0),
+	TransactedAt: time.Unix(0, 0),
+	Date:         "1970-01-01",
+	Name:         "Sample Store",
+	Extra:        map[string]interface{}{},
+	Account:      TemplateAccount{Extra: map[string]interface{}{}},
+}
+
+// parseTransactionTemplates compiles a name and a notes template, nil when empty.
+// The notes must keep the "ID: <id>" marker that interrupted imports are recovered by.
+func parseTransactionTemplates(nameText, notesText string) (*template.Template, *template.Template, error) {
+	name, err := parseTransactionTemplate("name_template", nameText)
+	if err != nil {
+		return nil, nil, err
+	}
+	notes, err := parseTransactionTemplate("notes_template", notesText)
+	if err != nil {
+		return nil, nil, err
+	}
+	if notes != nil {
+		var out strings.Builder
+		if err := notes.Execute(&out, sampleTemplateData); err != nil {
+			return nil, nil, fmt.Errorf("notes_template: %w", err)
+		}
+		if !notesReferenceTransaction(out.String(), sampleTemplateData.ID) {
+			return nil, nil, fmt.Errorf(`notes_template must contain "ID: {{.ID}}", it identifies imported transactions`)
+		}
+	}
+	return name, notes, nil
+}
+
+// parseTransactionTemplate compiles one template and checks it executes with sample data
+func parseTransactionTemplate(field, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(field).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", field, err)
+	}
+	if err := tmpl.Execute(&strings.Builder{}, sampleTemplateData); err != nil {
+		return nil, fmt.Errorf("%s: %w", field, err)
+	}
+	return tmpl, nil
+}
+
+// newTransactionTemplateData collects the fields of a transaction for the templates
+func newTransactionTemplateData(account SFAccount, accConfig AccountConfig, tx SFTransaction, payload SureTransaction) TransactionTemplateData {
+	data := TransactionTemplateData{
+		ID:          tx.ID,
+		Amount:      payload.Amount,
+		Description: tx.Description,
+		Payee:       tx.Payee,
+		Memo:        tx.Memo,
+		Pending:     tx.Pending,
+		Date:        payload.Date,
+		Name:        payload.Name,
+		Extra:       tx.Extra,
+		Account: TemplateAccount{
+			ID:         account.ID,
+			Name:       account.Name,
+			MappedName: accConfig.Name,
+			Currency:   account.Currency,
+			OrgDomain:  account.Org.Domain,
+			Extra:      account.Extra,
+		},
+	}
+	if tx.Posted != 0 {
+		data.Posted = time.Unix(tx.Posted, 0)
+	}
+	if tx.TransactedAt != 0 {
+		data.TransactedAt = time.Unix(tx.TransactedAt, 0)
+	}
+	return data
+}
+
+// executeTransactionTemplate renders a template, returning fallback when it fails
+// or renders nothing
+func executeTransactionTemplate(tmpl *template.Template, data TransactionTemplateData, fallback string) string {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		log.Printf("Warning: %s failed for transaction %s, using the default: %v", tmpl.Name(), data.ID, err)
+		return fallback
+	}
+	if text := strings.TrimSpace(out.String()); text != "" {
+		return text
+	}
+	return fallback
+}
+
+// compileTemplates compiles the global and per-account name and notes templates
+func (c *Config) compileTemplates() error {
+	var err error
+	if c.nameTemplate, c.notesTemplate, err = parseTransactionTemplates(c.NameTemplate, c.NotesTemplate); err != nil {
+		return err
+	}
+	for id, accConfig := range c.AccountMap {
+		if accConfig.nameTemplate, accConfig.notesTemplate, err = parseTransactionTemplates(accConfig.NameTemplate, accConfig.NotesTemplate); err != nil {
+			return fmt.Errorf("%s: %w", accConfig.Name, err)
+		}
+		c.AccountMap[id] = accConfig
+	}
+	return nil
+}
+
+// transactionTemplates returns the name and notes templates of an account, falling
+// back to the global ones; nil means the built-in format
+func (c Config) transactionTemplates(accConfig AccountConfig) (*template.Template, *template.Template) {
+	name, notes := c.nameTemplate, c.notesTemplate
+	if accConfig.nameTemplate != nil {
+		name = accConfig.nameTemplate
+	}
+	if accConfig.notesTemplate != nil {
+		notes = accConfig.notesTemplate
+	}
+	return name, notes
+}
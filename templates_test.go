@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTransactionTemplates(t *testing.T) {
+	tests := []struct {
+		name, notes string
+		wantErr     string
+	}{
+		{"", "", ""},
+		{"{{.Payee}}", "{{.Memo}} (ID: {{.ID}})", ""},
+		{"", "Synced {{.Date}}", "must contain"},
+		{"", "ID: {{.Account.ID}}", "must contain"},
+		{"{{.Payee", "", "name_template"},
+		{"{{.Unknown}}", "", "name_template"},
+		{"", "{{.ID | nosuchfunc}}", "notes_template"},
+	}
+	for _, tt := range tests {
+		_, _, err := parseTransactionTemplates(tt.name, tt.notes)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("parseTransactionTemplates(%q, %q): %v", tt.name, tt.notes, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("parseTransactionTemplates(%q, %q) error = %v, want one about %q", tt.name, tt.notes, err, tt.wantErr)
+		}
+	}
+
+	config := Config{AccountMap: map[string]AccountConfig{"acc": {SureID: "s", Name: "Checking", NotesTemplate: "{{.Memo}}"}}}
+	if err := config.compileTemplates(); err == nil || !strings.Contains(err.Error(), "Checking") {
+		t.Errorf("compileTemplates error = %v, want one naming the account", err)
+	}
+}
+
+func TestBuildSureTransactionTemplates(t *testing.T) {
+	config := Config{
+		NameTemplate:  "{{.Name}} ({{.Account.MappedName}})",
+		NotesTemplate: "{{.Account.OrgDomain}} {{.Posted.UTC.Format \"02/01/2006\"}} {{.Name}}\nID: {{.ID}}",
+		AccountMap: map[string]AccountConfig{
+			"acc":   {SureID: "s-acc", Name: "Checking"},
+			"card":  {SureID: "s-card", Name: "Card", NameTemplate: "{{.Description}}", NotesTemplate: "{{if .Pending}}{{.Extra.code.detail}}{{end}}ID: {{.ID}}"},
+			"blank": {SureID: "s-blank", Name: "Blank", NameTemplate: "{{.Memo}}"},
+		},
+	}
+	if err := config.compileTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	account := SFAccount{ID: "acc", Name: "Everyday", Org: SFOrg{Domain: "bank.example"}}
+	tx := SFTransaction{ID: "T-1", Amount: "-4.50", Description: "COFFEE HOUSE", Posted: 1_717_243_200}
+
+	payload := buildSureTransaction(config, account, config.AccountMap["acc"], tx)
+	if payload.Name != "COFFEE HOUSE (Checking)" {
+		t.Errorf("Name = %q", payload.Name)
+	}
+	if payload.Notes != "bank.example 01/06/2024 COFFEE HOUSE (Checking)\nID: T-1" {
+		t.Errorf("Notes = %q", payload.Notes)
+	}
+
+	// Account templates replace the global ones; an execution error falls back to the default
+	tx.Pending, tx.Extra = true, map[string]interface{}{"code": "7"}
+	payload = buildSureTransaction(config, account, config.AccountMap["card"], tx)
+	if payload.Name != "COFFEE HOUSE" || payload.Notes != "[Pending] Imported via SimpleFIN. ID: T-1" {
+		t.Errorf("payload = %+v, want the description as name and the default notes", payload)
+	}
+
+	// A name rendering nothing falls back to the default
+	payload = buildSureTransaction(config, account, config.AccountMap["blank"], tx)
+	if payload.Name != "COFFEE HOUSE" {
+		t.Errorf("Name = %q, want the default name", payload.Name)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	// A posted transaction may differ from its pending version by this fraction of the amount (e.g. tips)
+	pendingAmountTolerance = 0.25
+	// A posted transaction must be dated within this many days after its pending version
+	pendingMatchDays = 10
+)
+
+// TransactionSyncResult counts the Sure writes made for one account
+type TransactionSyncResult struct {
+	Created int
+	Updated int
+	Deleted int
+	Failed  int
+	// Transactions skipped because an earlier import attempt is still unresolved
+	Deferred int
+}
+
+// syncOpKind identifies what a syncOp does in Sure
+type syncOpKind int
+
+const (
+	opCreate         syncOpKind = iota // Create a new transaction
+	opUpdate                           // Push a bank revision to an imported transaction
+	opReplacePending                   // Turn an imported pending transaction into its posted version
+	opDeletePending                    // Remove a pending transaction that never posted
+)
+
+// syncOp is one planned write to Sure and its outcome
+type syncOp struct {
+	kind     syncOpKind
+	tx       SFTransaction
+	payload  SureTransaction
+	update   SureTransactionUpdate // Fields sent for opUpdate and opReplacePending
+	entry    LedgerEntry           // Ledger entry for the transaction once the write succeeds
+	previous LedgerEntry           // Existing ledger entry that is updated, replaced or deleted
+	changes  []string
+
+	err error
+}
+
+// SyncAccountTransactions imports the account's new SimpleFIN transactions into Sure,
+// pushes revisions of already imported ones as updates and, when pending
+// transactions are enabled, reconciles pending imports with their posted versions.
+// Transactions are categorized by the first matching rule, if any.
+func SyncAccountTransactions(config Config, ledger *Ledger, rules *RuleSet, account SFAccount, accConfig AccountConfig) TransactionSyncResult {
+	ops, deferred := planAccountTransactions(config, ledger, rules, account, accConfig)
+	if dryRun != nil {
+		return dryRun.recordOps(ops)
+	}
+	result := executeSyncOps(config, ledger, ops)
+	result.Deferred = deferred
+	return result
+}
+
+// planAccountTransactions compares the fetched transactions with the ledger and
+// decides which writes to Sure are needed, without performing any of them. It also
+// returns how many transactions were left out because an earlier import is unresolved.
+func planAccountTransactions(config Config, ledger *Ledger, rules *RuleSet, account SFAccount, accConfig AccountConfig) ([]syncOp, int) {
+	var ops []syncOp
+	deferred := 0
+
+	pendingEnabled := config.PendingEnabled(account.ID)
+	var outstanding []LedgerEntry
+	if pendingEnabled {
+		var err error
+		outstanding, err = ledger.PendingEntries(account.ID)
+		if err != nil {
+			log.Printf("Warning: Failed to read pending transactions for %s: %v", accConfig.Name, err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, tx := range account.Transactions {
+		seen[tx.ID] = true
+	}
+
+	planned := make(map[string]bool)
+	for _, tx := range account.Transactions {
+		if tx.Pending && !pendingEnabled {
+			continue
+		}
+		if planned[tx.ID] {
+			continue // Reported by two adjoining pages
+		}
+		planned[tx.ID] = true
+
+		payload := buildSureTransaction(config, account, accConfig, tx)
+		rules.Apply(&payload, tx, account, accConfig)
+		if !accConfig.InSyncRange(payload.Date) {
+			continue
+		}
+
+		entry, processed, err := ledger.Get(tx.ID)
+		if err != nil {
+			log.Printf("Failed to read ledger for tx %s: %v", tx.ID, err)
+			continue
+		}
+
+		if processed {
+			if op, ok := planUpdate(entry, tx, payload); ok {
+				ops = append(ops, op)
+			}
+			continue
+		}
+
+		if ledger.HasIntent(tx.ID) {
+			log.Printf("Skipping tx %s: an earlier import attempt is still unresolved", tx.ID)
+			deferred++
+			continue
+		}
+
+		if !tx.Pending {
+			if idx := matchPendingTransaction(outstanding, seen, tx.Amount, payload.Date); idx >= 0 {
+				pending := outstanding[idx]
+				entry := newLedgerEntry(account.ID, tx, payload)
+				entry.SureTransactionID = pending.SureTransactionID
+				entry.ImportedAt = pending.ImportedAt
+				entry.UpdatedAt = time.Now()
+				entry.ReplacedPendingID = pending.TransactionID
+				ops = append(ops, syncOp{kind: opReplacePending, tx: tx, payload: payload, update: revisionUpdate(pending, tx, payload), entry: entry, previous: pending})
+				outstanding = append(outstanding[:idx], outstanding[idx+1:]...)
+				continue
+			}
+		}
+
+		ops = append(ops, syncOp{kind: opCreate, tx: tx, payload: payload, entry: newLedgerEntry(account.ID, tx, payload)})
+	}
+
+	// Pending transactions that are no longer reported and were never matched to a posted one
+	for _, pending := range outstanding {
+		if seen[pending.TransactionID] {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", pending.Date)
+		if err != nil || time.Since(date) < config.PendingExpiry() {
+			continue
+		}
+		ops = append(ops, syncOp{kind: opDeletePending, previous: pending})
+	}
+
+	return ops, deferred
+}
+
+// newLedgerEntry builds the ledger entry for a transaction sent to Sure as payload.
+// The amount is recorded as SimpleFIN reports it, so that changing invert_amounts
+// is not mistaken for a bank revision.
+func newLedgerEntry(accountID string, tx SFTransaction, payload SureTransaction) LedgerEntry {
+	return LedgerEntry{
+		AccountID:       accountID,
+		TransactionID:   tx.ID,
+		SureAccountID:   payload.AccountID,
+		Amount:          tx.Amount,
+		Date:            payload.Date,
+		Posted:          tx.Posted,
+		TransactedAt:    tx.TransactedAt,
+		DescriptionHash: hashDescription(tx.Description),
+		ImportedAt:      time.Now(),
+		Pending:         tx.Pending,
+	}
+}
+
+// planUpdate compares a re-fetched transaction with its ledger entry and plans an
+// update of the Sure transaction if the bank has revised it. A revision of a
+// timestamp the date source does not use changes nothing in Sure and is left out.
+func planUpdate(entry LedgerEntry, tx SFTransaction, payload SureTransaction) (syncOp, bool) {
+	if entry.SureTransactionID == "" {
+		return syncOp{}, false // Migrated or unconfirmed import, nothing to update in Sure
+	}
+
+	changes := entry.Changes(tx)
+	if len(changes) == 0 {
+		return syncOp{}, false
+	}
+	update := revisionUpdate(entry, tx, payload)
+	if update == (SureTransactionUpdate{}) {
+		return syncOp{}, false
+	}
+
+	updated := entry
+	updated.Amount = tx.Amount
+	updated.Date = payload.Date
+	updated.Posted = tx.Posted
+	updated.TransactedAt = tx.TransactedAt
+	updated.DescriptionHash = hashDescription(tx.Description)
+	updated.Pending = tx.Pending
+	updated.UpdatedAt = time.Now()
+	return syncOp{kind: opUpdate, tx: tx, payload: payload, update: update, entry: updated, previous: entry, changes: changes}, true
+}
+
+// revisionUpdate returns the fields to change in Sure when the bank revises the
+// transaction recorded as previous. Amount and date are brought in line with the
+// bank. The name is only replaced when the description changed, and the notes only
+// when a pending transaction posts, so that edits made in Sure are otherwise kept.
+func revisionUpdate(previous LedgerEntry, tx SFTransaction, payload SureTransaction) SureTransactionUpdate {
+	var update SureTransactionUpdate
+	if previous.Amount != tx.Amount {
+		update.Amount = payload.Amount
+	}
+	if previous.Date != payload.Date {
+		update.Date = payload.Date
+	}
+	if previous.DescriptionHash != hashDescription(tx.Description) {
+		update.Name = payload.Name
+	}
+	if previous.Pending && !tx.Pending {
+		update.Notes = payload.Notes // Drops the [Pending] marker and, for a replaced pending, carries the posted ID
+	}
+	return update
+}
+
+// matchPendingTransaction finds the outstanding pending transaction that a newly
+// posted transaction, with the given SimpleFIN amount and date, most likely replaces.
+// Pendings still reported by SimpleFIN are not candidates. Returns -1 if there is no match.
+func matchPendingTransaction(outstanding []LedgerEntry, seen map[string]bool, amount, date string) int {
+	postedAmount, err := parseAmount(amount)
+	if err != nil {
+		return -1
+	}
+	postedDate, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return -1
+	}
+
+	best := -1
+	bestDiff := math.MaxFloat64
+	for i, pending := range outstanding {
+		if seen[pending.TransactionID] || pending.SureTransactionID == "" {
+			continue
+		}
+		pendingAmount, err := parseAmount(pending.Amount)
+		if err != nil || (pendingAmount < 0) != (postedAmount < 0) {
+			continue
+		}
+		pendingDate, err := time.Parse("2006-01-02", pending.Date)
+		if err != nil {
+			continue
+		}
+		days := postedDate.Sub(pendingDate).Hours() / 24
+		if days < -1 || days > pendingMatchDays {
+			continue
+		}
+		diff := math.Abs(postedAmount - pendingAmount)
+		if diff > math.Abs(pendingAmount)*pendingAmountTolerance {
+			continue
+		}
+		if diff < bestDiff {
+			best, bestDiff = i, diff
+		}
+	}
+	return best
+}
+
+// buildSureTransaction formats a SimpleFIN transaction for the Sure API. The payee,
+// when the institution provides one, is used as the name after normalization. The
+// memo and, when the name differs from it, the raw description are kept in the notes.
+// The amount is inverted when the account is configured to. Name and notes templates,
+// when configured, replace the default name and notes.
+func buildSureTransaction(config Config, account SFAccount, accConfig AccountConfig, tx SFTransaction) SureTransaction {
+	txDate := formatUnixDate(transactionTimestamp(tx, config.DateSourceFor(account.ID)))
+	txName := tx.Payee
+	if txName == "" {
+		txName = tx.Description
+	}
+	txName = config.Normalize.Apply(txName)
+	if txName == "" {
+		txName = txDate
+	}
+	payload := SureTransaction{
+		AccountID: accConfig.SureID,
+		Amount:    accConfig.sureAmount(tx.Amount),
+		Date:      txDate,
+		Name:      txName,
+	}
+
+	nameTemplate, notesTemplate := config.transactionTemplates(accConfig)
+	if nameTemplate != nil {
+		payload.Name = executeTransactionTemplate(nameTemplate, newTransactionTemplateData(account, accConfig, tx, payload), txName)
+	}
+
+	notes := fmt.Sprintf("Imported via SimpleFIN. ID: %s", tx.ID)
+	if tx.Description != "" && tx.Description != payload.Name {
+		notes = "Description: " + tx.Description + "\n" + notes
+	}
+	if tx.Memo != "" {
+		notes = tx.Memo + "\n" + notes
+	}
+	if tx.Pending {
+		notes = "[Pending] " + notes
+	}
+	payload.Notes = notes
+	if notesTemplate != nil {
+		payload.Notes = executeTransactionTemplate(notesTemplate, newTransactionTemplateData(account, accConfig, tx, payload), notes)
+	}
+	return payload
+}
+
+// transactionTimestamp picks the Unix timestamp used as the transaction date
+func transactionTimestamp(tx SFTransaction, dateSource string) int64 {
+	switch dateSource {
+	case DateSourcePosted:
+		if tx.Posted != 0 {
+			return tx.Posted
+		}
+		return tx.TransactedAt
+	case DateSourceTransacted:
+		return tx.TransactedAt
+	default:
+		if tx.TransactedAt != 0 {
+			return tx.TransactedAt
+		}
+		return tx.Posted
+	}
+}
+
+// formatUnixDate formats a SimpleFIN timestamp as the date sent to Sure
+func formatUnixDate(ts int64) string {
+	return time.Unix(ts, 0).Format("2006-01-02")
+}
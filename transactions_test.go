@@ -0,0 +1,105 @@
+package main
+
+import "testing"
+
+func TestMatchPendingTransaction(t *testing.T) {
+	outstanding := []LedgerEntry{
+		{TransactionID: "P-1", SureTransactionID: "s1", Amount: "-20.00", Date: "2024-03-01", Pending: true},
+		{TransactionID: "P-2", SureTransactionID: "s2", Amount: "-24.00", Date: "2024-03-01", Pending: true}, // Tip added
+		{TransactionID: "P-3", SureTransactionID: "s3", Amount: "50.00", Date: "2024-03-01", Pending: true},
+		{TransactionID: "P-4", Amount: "-75.00", Date: "2024-03-01", Pending: true}, // Never confirmed in Sure
+	}
+
+	tests := []struct {
+		name   string
+		seen   map[string]bool
+		amount string
+		date   string
+		want   int
+	}{
+		{"exact amount", nil, "-20.00", "2024-03-03", 0},
+		{"closest amount wins", nil, "-23.50", "2024-03-03", 1},
+		{"within tolerance", nil, "-17.00", "2024-03-02", 0},
+		{"beyond tolerance", nil, "-40.00", "2024-03-02", -1},
+		{"opposite sign", nil, "20.00", "2024-03-02", -1},
+		{"deposit", nil, "50.00", "2024-03-02", 2},
+		{"posted a day before", nil, "-20.00", "2024-02-29", 0},
+		{"posted too early", nil, "-20.00", "2024-02-27", -1},
+		{"posted too late", nil, "-20.00", "2024-03-12", -1},
+		{"still reported pending", map[string]bool{"P-1": true}, "-20.00", "2024-03-02", 1},
+		{"no Sure transaction", nil, "-75.00", "2024-03-02", -1},
+		{"unparseable amount", nil, "n/a", "2024-03-02", -1},
+	}
+	for _, tt := range tests {
+		got := matchPendingTransaction(outstanding, tt.seen, tt.amount, tt.date)
+		if got != tt.want {
+			t.Errorf("%s: matchPendingTransaction = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRevisionUpdate(t *testing.T) {
+	previous := LedgerEntry{TransactionID: "T-1", Amount: "-5.00", Date: "2024-01-01", DescriptionHash: hashDescription("COFFEE")}
+	payload := SureTransaction{Amount: "-5.00", Date: "2024-01-01", Name: "Coffee", Notes: "Imported via SimpleFIN. ID: T-1"}
+
+	tests := []struct {
+		name     string
+		previous func(LedgerEntry) LedgerEntry
+		tx       SFTransaction
+		payload  func(SureTransaction) SureTransaction
+		want     SureTransactionUpdate
+	}{
+		{"amount only", nil, SFTransaction{ID: "T-1", Amount: "-5.50", Description: "COFFEE"},
+			func(p SureTransaction) SureTransaction { p.Amount = "-5.50"; return p },
+			SureTransactionUpdate{Amount: "-5.50"}},
+		{"inverted amount sent as imported", nil, SFTransaction{ID: "T-1", Amount: "-5.50", Description: "COFFEE"},
+			func(p SureTransaction) SureTransaction { p.Amount = "5.50"; return p },
+			SureTransactionUpdate{Amount: "5.50"}},
+		{"invert_amounts changed", nil, SFTransaction{ID: "T-1", Amount: "-5.00", Description: "COFFEE"},
+			func(p SureTransaction) SureTransaction { p.Amount = "5.00"; return p },
+			SureTransactionUpdate{}},
+		{"date only", nil, SFTransaction{ID: "T-1", Amount: "-5.00", Description: "COFFEE"},
+			func(p SureTransaction) SureTransaction { p.Date = "2024-01-02"; return p },
+			SureTransactionUpdate{Date: "2024-01-02"}},
+		{"description renames", nil, SFTransaction{ID: "T-1", Amount: "-5.00", Description: "COFFEE SHOP"},
+			func(p SureTransaction) SureTransaction { p.Name = "Coffee Shop"; return p },
+			SureTransactionUpdate{Name: "Coffee Shop"}},
+		{"pending posts", func(e LedgerEntry) LedgerEntry { e.Pending = true; return e }, SFTransaction{ID: "T-1", Amount: "-5.00", Description: "COFFEE"},
+			nil, SureTransactionUpdate{Notes: "Imported via SimpleFIN. ID: T-1"}},
+	}
+	for _, tt := range tests {
+		prev, p := previous, payload
+		if tt.previous != nil {
+			prev = tt.previous(prev)
+		}
+		if tt.payload != nil {
+			p = tt.payload(p)
+		}
+		if got := revisionUpdate(prev, tt.tx, p); got != tt.want {
+			t.Errorf("%s: revisionUpdate = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPlanUpdateSkipsRevisionsInvisibleInSure(t *testing.T) {
+	tx := SFTransaction{ID: "T-1", Amount: "-5.00", Description: "COFFEE", Posted: 1_717_243_200, TransactedAt: 1_717_070_400}
+	payload := SureTransaction{Amount: "-5.00", Date: formatUnixDate(tx.TransactedAt), Name: "Coffee"}
+	entry := newLedgerEntry("acc", tx, payload)
+	entry.SureTransactionID = "s1"
+
+	revised := tx
+	revised.Posted += 24 * 60 * 60
+	if op, ok := planUpdate(entry, revised, payload); ok {
+		t.Errorf("planned %+v for a posted date the transacted date source does not use", op.update)
+	}
+
+	revised.Amount = "-6.00"
+	payload.Amount = "-6.00"
+	op, ok := planUpdate(entry, revised, payload)
+	if !ok || op.update != (SureTransactionUpdate{Amount: "-6.00"}) {
+		t.Fatalf("planUpdate = %+v, %v, want an amount update", op.update, ok)
+	}
+	if op.entry.Posted != revised.Posted || op.entry.Amount != "-6.00" {
+		t.Errorf("updated entry %+v does not carry the revised fingerprint", op.entry)
+	}
+}
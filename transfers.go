@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTransferWindowDays = 3
+
+// unknownTransferID marks a side of a transfer whose Sure ID is not known: journaled
+// before the transfer is created, or recovered from a Sure that does not report it
+const unknownTransferID = "unknown"
+
+// TransferConfig configures the detection of transfers between mapped accounts
+type TransferConfig struct {
+	Enabled      bool       `json:"enabled,omitzero"`
+	Tolerance    float64    `json:"tolerance,omitzero"`     // Allowed difference between the two amounts (default 0, exact)
+	WindowDays   int        `json:"window_days,omitzero"`   // Maximum days between the two sides (default 3)
+	AllowedPairs [][]string `json:"allowed_pairs,omitzero"` // Pairs of SimpleFIN account IDs or mapped names; empty allows every pair
+}
+
+// Window returns how far apart the two sides of a transfer may be dated
+func (t TransferConfig) Window() time.Duration {
+	days := t.WindowDays
+	if days <= 0 {
+		days = defaultTransferWindowDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// transferSide is one half of a candidate transfer: a transaction fetched this run
+// that is not imported yet, or a recent ledger entry already imported as a plain transaction
+type transferSide struct {
+	accountID string
+	accConfig AccountConfig
+	tx        SFTransaction   // Fetched transaction, empty for a ledger entry
+	payload   SureTransaction // Payload the transaction would be imported with
+	existing  *LedgerEntry    // Already imported entry, nil for a new transaction
+	amount    float64         // SimpleFIN amount, negative for an outflow and positive for an inflow
+	date      time.Time
+}
+
+// transactionID returns the SimpleFIN ID of the side's transaction
+func (s transferSide) transactionID() string {
+	if s.existing != nil {
+		return s.existing.TransactionID
+	}
+	return s.tx.ID
+}
+
+// plannedTransfer pairs an outflow with the matching inflow in another account
+type plannedTransfer struct {
+	from, to transferSide
+}
+
+// SyncTransfers finds transactions fetched this run that mirror each other across
+// two mapped accounts (opposite signs, equal amounts within the tolerance, dated
+// within the window) and creates each pair in Sure as one linked transfer. One side
+// may be a transaction imported on an earlier run, in which case that plain
+// transaction is replaced by the transfer. It returns the IDs of the fetched
+// transactions now imported as transfers, which must not be imported again.
+func SyncTransfers(config Config, ledger *Ledger, accounts []SFAccount, skip map[string]bool) map[string]bool {
+	handled := make(map[string]bool)
+	if !config.Transfers.Enabled {
+		return handled
+	}
+
+	sides := transferCandidates(config, ledger, accounts, skip)
+	if len(sides) == 0 {
+		return handled
+	}
+	history, err := recentTransferSides(config, ledger, sides)
+	if err != nil {
+		log.Printf("Warning: Failed to read recent transactions for transfer detection: %v", err)
+	}
+
+	for _, transfer := range matchTransfers(config, append(sides, history...)) {
+		if err := createTransfer(config, ledger, transfer); err != nil {
+			log.Printf("Failed to create transfer %s -> %s (%s on %s): %v", transfer.from.accConfig.Name, transfer.to.accConfig.Name,
+				transfer.to.payload.Amount, transfer.from.payload.Date, err)
+			continue
+		}
+		for _, side := range []transferSide{transfer.from, transfer.to} {
+			if side.existing == nil {
+				handled[side.tx.ID] = true
+			}
+		}
+	}
+	return handled
+}
+
+// transferCandidates collects the posted transactions of this run that are not in the ledger yet
+func transferCandidates(config Config, ledger *Ledger, accounts []SFAccount, skip map[string]bool) []transferSide {
+	var sides []transferSide
+	for _, account := range accounts {
+		accConfig, mapped := config.AccountMap[account.ID]
+		if !mapped || accConfig.BalanceOnly || accConfig.Closed || skip[account.ID] {
+			continue
+		}
+		for _, tx := range account.Transactions {
+			if tx.Pending || ledger.HasIntent(tx.ID) {
+				continue
+			}
+			if _, processed, err := ledger.Get(tx.ID); err != nil || processed {
+				continue
+			}
+			payload := buildSureTransaction(config, account, accConfig, tx)
+			if !accConfig.InSyncRange(payload.Date) {
+				continue
+			}
+			side, ok := newTransferSide(account.ID, accConfig, tx.Amount, payload)
+			if !ok {
+				continue
+			}
+			side.tx = tx
+			sides = append(sides, side)
+		}
+	}
+	return sides
+}
+
+// recentTransferSides returns the ledger entries dated within the window of the
+// candidates that could be the other side of a transfer
+func recentTransferSides(config Config, ledger *Ledger, candidates []transferSide) ([]transferSide, error) {
+	earliest := candidates[0].date
+	for _, c := range candidates {
+		if c.date.Before(earliest) {
+			earliest = c.date
+		}
+	}
+	entries, err := ledger.EntriesSince(earliest.Add(-config.Transfers.Window()).Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	var sides []transferSide
+	for i := range entries {
+		entry := entries[i]
+		accConfig, mapped := config.AccountMap[entry.AccountID]
+		if !mapped || entry.Pending || entry.TransferID != "" || entry.SureTransactionID == "" {
+			continue
+		}
+		payload := SureTransaction{AccountID: accConfig.SureID, Amount: accConfig.sureAmount(entry.Amount), Date: entry.Date}
+		side, ok := newTransferSide(entry.AccountID, accConfig, entry.Amount, payload)
+		if !ok {
+			continue
+		}
+		side.existing = &entry
+		sides = append(sides, side)
+	}
+	return sides, nil
+}
+
+// newTransferSide parses the SimpleFIN amount of a transaction and the date of its
+// payload. The direction is taken from SimpleFIN rather than the payload, whose sign
+// depends on invert_amounts.
+func newTransferSide(accountID string, accConfig AccountConfig, sfAmount string, payload SureTransaction) (transferSide, bool) {
+	amount, err := parseAmount(sfAmount)
+	if err != nil || amount == 0 {
+		return transferSide{}, false
+	}
+	date, err := time.Parse("2006-01-02", payload.Date)
+	if err != nil {
+		return transferSide{}, false
+	}
+	return transferSide{accountID: accountID, accConfig: accConfig, payload: payload, amount: amount, date: date}, true
+}
+
+// matchTransfers pairs outflows with inflows in other accounts, closest amount and
+// date first. At least one side of every pair is a transaction fetched this run.
+func matchTransfers(config Config, sides []transferSide) []plannedTransfer {
+	type candidate struct {
+		out, in          int
+		amountDiff, days float64
+	}
+	var candidates []candidate
+	for i, out := range sides {
+		if out.amount >= 0 {
+			continue
+		}
+		for j, in := range sides {
+			if in.amount <= 0 || in.accountID == out.accountID || (out.existing != nil && in.existing != nil) {
+				continue
+			}
+			amountDiff := math.Abs(math.Abs(out.amount) - in.amount)
+			days := math.Abs(in.date.Sub(out.date).Hours() / 24)
+			if amountDiff > config.Transfers.Tolerance+0.005 || days > config.Transfers.Window().Hours()/24 {
+				continue
+			}
+			if !transferPairAllowed(config.Transfers.AllowedPairs, out, in) {
+				continue
+			}
+			candidates = append(candidates, candidate{out: i, in: j, amountDiff: amountDiff, days: days})
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		if candidates[a].amountDiff != candidates[b].amountDiff {
+			return candidates[a].amountDiff < candidates[b].amountDiff
+		}
+		return candidates[a].days < candidates[b].days
+	})
+
+	used := make(map[int]bool)
+	var transfers []plannedTransfer
+	for _, c := range candidates {
+		if used[c.out] || used[c.in] {
+			continue
+		}
+		used[c.out], used[c.in] = true, true
+		transfers = append(transfers, plannedTransfer{from: sides[c.out], to: sides[c.in]})
+	}
+	return transfers
+}
+
+// transferPairAllowed reports whether the allow-list permits a transfer between the two accounts
+func transferPairAllowed(pairs [][]string, a, b transferSide) bool {
+	if len(pairs) == 0 {
+		return true
+	}
+	is := func(ref string, side transferSide) bool {
+		return ref == side.accountID || strings.EqualFold(ref, side.accConfig.Name)
+	}
+	for _, pair := range pairs {
+		if len(pair) != 2 {
+			continue
+		}
+		if (is(pair[0], a) && is(pair[1], b)) || (is(pair[0], b) && is(pair[1], a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// createTransfer creates the transfer in Sure and records both sides in the ledger.
+// A side imported earlier as a plain transaction is deleted from Sure once the
+// transfer exists. New sides are journaled as intents first, like other creations.
+// A Sure transfer moves a single amount, so a pair whose amounts differ (matched
+// within the tolerance) is refused rather than booked wrong on one side.
+func createTransfer(config Config, ledger *Ledger, t plannedTransfer) error {
+	from, to := t.from, t.to
+	if math.Abs(math.Abs(from.amount)-to.amount) >= 0.005 {
+		return fmt.Errorf("%.2f left %s but %.2f reached %s, importing both as plain transactions",
+			math.Abs(from.amount), from.accConfig.Name, to.amount, to.accConfig.Name)
+	}
+	transfer := SureTransfer{
+		FromAccountID: from.accConfig.SureID,
+		ToAccountID:   to.accConfig.SureID,
+		Amount:        strconv.FormatFloat(to.amount, 'f', 2, 64),
+		Date:          from.payload.Date,
+		Notes:         fmt.Sprintf("Transfer imported via SimpleFIN. ID: %s, ID: %s", from.transactionID(), to.transactionID()),
+	}
+
+	if dryRun != nil {
+		dryRun.record(PlannedWrite{Action: "create_transfer", Transfer: &transfer})
+		for _, side := range []transferSide{from, to} {
+			if side.existing != nil {
+				dryRun.record(PlannedWrite{Action: "delete_transaction", SureID: side.existing.SureTransactionID,
+					Changes: []string{"replaced by transfer"}})
+			}
+		}
+		return nil
+	}
+
+	entries := make([]LedgerEntry, 2)
+	intents := make([]LedgerEntry, 2)
+	for i, side := range []transferSide{from, to} {
+		if side.existing != nil {
+			entries[i] = *side.existing
+		} else {
+			entries[i] = newLedgerEntry(side.accountID, side.tx, side.payload)
+		}
+		// A side replacing a plain transaction is journaled too, so that a crash
+		// before the ledger commit cannot leave that transaction next to the transfer
+		intents[i] = entries[i]
+		intents[i].TransferID = unknownTransferID
+		intents[i].SureAccountID = side.accConfig.SureID
+	}
+	if err := ledger.RecordIntents(intents); err != nil {
+		return fmt.Errorf("journal transfer: %w", err)
+	}
+
+	created, err := CreateSureTransfer(config.SureBaseURL, config.SureAPIKey, transfer)
+	commit := LedgerBatch{}
+	var apiErr *SureAPIError
+	if err == nil || errors.As(err, &apiErr) {
+		// Otherwise the intents stay so the next run can check whether the transfer was created
+		for _, intent := range intents {
+			commit.ClearIntents = append(commit.ClearIntents, intent.TransactionID)
+		}
+	}
+	if err != nil {
+		if commitErr := ledger.Commit(commit); commitErr != nil {
+			log.Printf("Warning: Failed to record transfer outcome in ledger: %v", commitErr)
+		}
+		return err
+	}
+
+	for i, side := range []transferSide{from, to} {
+		if side.existing != nil {
+			if err := DeleteSureTransaction(config.SureBaseURL, config.SureAPIKey, side.existing.SureTransactionID); err != nil {
+				log.Printf("%sWarning: Transfer created but the earlier transaction %s (Sure ID: %s) could not be deleted, remove it by hand: %v%s",
+					colorRed, side.existing.TransactionID, side.existing.SureTransactionID, err, colorReset)
+			}
+		}
+		entries[i].TransferID = created.ID
+		entries[i].SureTransactionID = created.OutflowTransactionID
+		if i == 1 {
+			entries[i].SureTransactionID = created.InflowTransactionID
+		}
+		entries[i].UpdatedAt = time.Now()
+		commit.Put = append(commit.Put, entries[i])
+	}
+	if err := ledger.Commit(commit); err != nil {
+		log.Printf("Warning: Failed to record transfer in ledger: %v", err)
+	}
+
+	log.Printf("Synced transfer: %s %s -> %s (Sure ID: %s)", transfer.Amount, from.accConfig.Name, to.accConfig.Name, created.ID)
+	return nil
+}
+
+// recoverTransferIntent resolves a side of a transfer whose outcome was never
+// recorded. The transfer exists if the side's Sure account has a transaction within
+// the transfer window whose notes reference the side, other than the plain
+// transaction the side was imported as. The side is then recorded as part of the
+// transfer and that plain transaction deleted; otherwise the side is left as it was
+// and the pair is detected again when next fetched.
+func recoverTransferIntent(config Config, ledger *Ledger, intent LedgerEntry) {
+	date, err := time.Parse("2006-01-02", intent.Date)
+	if err != nil {
+		log.Printf("Warning: Could not check transfer side %s, invalid date %q", intent.TransactionID, intent.Date)
+		return
+	}
+	window := config.Transfers.Window()
+	candidates, err := FetchSureTransactions(config.SureBaseURL, config.SureAPIKey, intent.SureAccountID,
+		date.Add(-window).Format("2006-01-02"), date.Add(window).Format("2006-01-02"))
+	if err != nil {
+		log.Printf("Warning: Could not check transfer side %s in Sure, will retry next run: %v", intent.TransactionID, err)
+		return
+	}
+
+	var leg *SureTransactionResponse
+	for i := range candidates {
+		if candidates[i].ID != intent.SureTransactionID && notesReferenceTransaction(candidates[i].Notes, intent.TransactionID) {
+			leg = &candidates[i]
+			break
+		}
+	}
+
+	commit := LedgerBatch{ClearIntents: []string{intent.TransactionID}}
+	if leg == nil {
+		if err := ledger.Commit(commit); err != nil {
+			log.Printf("Warning: Failed to resolve transfer side %s in ledger: %v", intent.TransactionID, err)
+			return
+		}
+		log.Printf("Recovered transfer side %s: transfer not in Sure, it will be detected again", intent.TransactionID)
+		return
+	}
+
+	entry := intent
+	entry.SureTransactionID = leg.ID
+	if leg.Transfer != nil && leg.Transfer.ID != "" {
+		entry.TransferID = leg.Transfer.ID
+	}
+	entry.UpdatedAt = time.Now()
+	commit.Put = []LedgerEntry{entry}
+	if err := ledger.Commit(commit); err != nil {
+		log.Printf("Warning: Failed to resolve transfer side %s in ledger: %v", intent.TransactionID, err)
+		return
+	}
+	log.Printf("Recovered transfer side %s: already in Sure (Sure ID: %s)", intent.TransactionID, leg.ID)
+
+	if intent.SureTransactionID == "" {
+		return
+	}
+	err = DeleteSureTransaction(config.SureBaseURL, config.SureAPIKey, intent.SureTransactionID)
+	var apiErr *SureAPIError
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound) { // Already deleted before the crash
+		log.Printf("%sWarning: Transfer recovered but the earlier transaction %s (Sure ID: %s) could not be deleted, remove it by hand: %v%s",
+			colorRed, intent.TransactionID, intent.SureTransactionID, err, colorReset)
+	}
+}
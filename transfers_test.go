@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// testTransferSide builds a candidate side for a fetched transaction with the given SimpleFIN amount
+func testTransferSide(t *testing.T, accountID, name, amount, date string) transferSide {
+	t.Helper()
+	return testInvertedTransferSide(t, accountID, name, amount, date, false)
+}
+
+// testInvertedTransferSide builds a candidate side for an account that may have invert_amounts set
+func testInvertedTransferSide(t *testing.T, accountID, name, amount, date string, invert bool) transferSide {
+	t.Helper()
+	accConfig := AccountConfig{SureID: "sure-" + accountID, Name: name, invert: invert}
+	side, ok := newTransferSide(accountID, accConfig, amount, SureTransaction{Amount: accConfig.sureAmount(amount), Date: date})
+	if !ok {
+		t.Fatalf("invalid side %s %s", amount, date)
+	}
+	side.tx = SFTransaction{ID: accountID + ":" + amount + ":" + date}
+	return side
+}
+
+func TestMatchTransfers(t *testing.T) {
+	config := Config{Transfers: TransferConfig{Enabled: true, Tolerance: 0.5}}
+
+	tests := []struct {
+		name  string
+		pairs [][]string
+		sides func() []transferSide
+		want  [][2]int // Indexes of the from and to sides
+	}{
+		{"exact pair", nil, func() []transferSide {
+			return []transferSide{
+				testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01"),
+				testTransferSide(t, "visa", "Visa", "100.00", "2024-05-02"),
+			}
+		}, [][2]int{{0, 1}}},
+		{"payment to an inverted card", nil, func() []transferSide {
+			return []transferSide{
+				testInvertedTransferSide(t, "visa", "Visa", "100.00", "2024-05-02", true), // Imported as -100.00
+				testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01"),
+			}
+		}, [][2]int{{1, 0}}},
+		{"same account", nil, func() []transferSide {
+			return []transferSide{
+				testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01"),
+				testTransferSide(t, "chk", "Checking", "100.00", "2024-05-01"),
+			}
+		}, nil},
+		{"beyond tolerance", nil, func() []transferSide {
+			return []transferSide{
+				testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01"),
+				testTransferSide(t, "visa", "Visa", "101.00", "2024-05-01"),
+			}
+		}, nil},
+		{"within tolerance", nil, func() []transferSide {
+			return []transferSide{
+				testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01"),
+				testTransferSide(t, "visa", "Visa", "100.50", "2024-05-01"),
+			}
+		}, [][2]int{{0, 1}}},
+		{"outside window", nil, func() []transferSide {
+			return []transferSide{
+				testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01"),
+				testTransferSide(t, "visa", "Visa", "100.00", "2024-05-05"),
+			}
+		}, nil},
+		{"closest amount then date first", nil, func() []transferSide {
+			return []transferSide{
+				testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01"),
+				testTransferSide(t, "visa", "Visa", "100.40", "2024-05-01"),
+				testTransferSide(t, "sav", "Savings", "100.00", "2024-05-03"),
+				testTransferSide(t, "amex", "Amex", "100.00", "2024-05-02"),
+			}
+		}, [][2]int{{0, 3}}},
+		{"each side used once", nil, func() []transferSide {
+			return []transferSide{
+				testTransferSide(t, "chk", "Checking", "-50.00", "2024-05-01"),
+				testTransferSide(t, "sav", "Savings", "-50.00", "2024-05-01"),
+				testTransferSide(t, "visa", "Visa", "50.00", "2024-05-01"),
+			}
+		}, [][2]int{{0, 2}}},
+		{"pair not allowed", [][]string{{"Checking", "Savings"}}, func() []transferSide {
+			return []transferSide{
+				testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01"),
+				testTransferSide(t, "visa", "Visa", "100.00", "2024-05-01"),
+			}
+		}, nil},
+		{"pair allowed by ID in either order", [][]string{{"visa", "chk"}}, func() []transferSide {
+			return []transferSide{
+				testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01"),
+				testTransferSide(t, "visa", "Visa", "100.00", "2024-05-01"),
+			}
+		}, [][2]int{{0, 1}}},
+		{"two imported sides", nil, func() []transferSide {
+			out := testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01")
+			in := testTransferSide(t, "visa", "Visa", "100.00", "2024-05-01")
+			out.existing, in.existing = &LedgerEntry{TransactionID: "a"}, &LedgerEntry{TransactionID: "b"}
+			return []transferSide{out, in}
+		}, nil},
+	}
+	for _, tt := range tests {
+		cfg := config
+		cfg.Transfers.AllowedPairs = tt.pairs
+		sides := tt.sides()
+		got := matchTransfers(cfg, sides)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d transfers, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i, w := range tt.want {
+			if got[i].from.transactionID() != sides[w[0]].transactionID() || got[i].to.transactionID() != sides[w[1]].transactionID() {
+				t.Errorf("%s: transfer %d is %s -> %s, want %s -> %s", tt.name, i, got[i].from.transactionID(), got[i].to.transactionID(),
+					sides[w[0]].transactionID(), sides[w[1]].transactionID())
+			}
+		}
+	}
+}
+
+func TestCreateTransferReplacesImportedSideOnlyOnceCreated(t *testing.T) {
+	tests := []struct {
+		name       string
+		inAmount   string
+		status     int
+		wantErr    bool
+		wantCalls  []string
+		wantLinked bool
+	}{
+		{"created", "100.00", http.StatusOK, false, []string{"POST /transfers", "DELETE /transactions/s-old"}, true},
+		{"rejected by Sure", "100.00", http.StatusUnprocessableEntity, true, []string{"POST /transfers"}, false},
+		{"amounts differ", "99.50", http.StatusOK, true, nil, false},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		var calls []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			calls = append(calls, r.Method+" "+r.URL.Path)
+			mu.Unlock()
+			if r.Method == "POST" {
+				w.WriteHeader(tt.status)
+				if tt.status == http.StatusOK {
+					fmt.Fprint(w, `{"id":"tr-1","outflow_transaction":{"id":"s-out"},"inflow_transaction":{"id":"s-in"}}`)
+				}
+			}
+		}))
+
+		ledger := openTestLedger(t)
+		old := LedgerEntry{AccountID: "chk", TransactionID: "T-old", SureTransactionID: "s-old", Amount: "-100.00", Date: "2024-05-01"}
+		if err := ledger.Commit(LedgerBatch{Put: []LedgerEntry{old}}); err != nil {
+			t.Fatal(err)
+		}
+		from := testTransferSide(t, "chk", "Checking", "-100.00", "2024-05-01")
+		from.existing = &old
+		to := testTransferSide(t, "visa", "Visa", tt.inAmount, "2024-05-01")
+		to.tx.ID = "T-new"
+
+		err := createTransfer(Config{SureBaseURL: server.URL}, ledger, plannedTransfer{from: from, to: to})
+		server.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: createTransfer error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if !slices.Equal(calls, tt.wantCalls) {
+			t.Errorf("%s: requests %v, want %v", tt.name, calls, tt.wantCalls)
+		}
+		entry, _, _ := ledger.Get("T-old")
+		if linked := entry.TransferID == "tr-1" && entry.SureTransactionID == "s-out"; linked != tt.wantLinked {
+			t.Errorf("%s: replaced side recorded as %+v", tt.name, entry)
+		}
+		if _, ok, _ := ledger.Get("T-new"); ok != tt.wantLinked {
+			t.Errorf("%s: new side in ledger = %v, want %v", tt.name, ok, tt.wantLinked)
+		}
+		if ledger.HasIntent("T-new") {
+			t.Errorf("%s: intent left although the outcome is known", tt.name)
+		}
+	}
+}
+
+func TestRecoverTransferIntents(t *testing.T) {
+	const notes = "Transfer imported via SimpleFIN. ID: T-old, ID: T-new"
+	tests := []struct {
+		name         string
+		transactions map[string]string // Sure account ID -> transactions JSON
+		wantOld      LedgerEntry
+		wantNew      bool
+		wantDeletes  []string
+	}{
+		{"transfer created before the crash", map[string]string{
+			"sure-chk":  `[{"id":"s-old","notes":"Imported via SimpleFIN. ID: T-old"},{"id":"s-out","notes":"` + notes + `","transfer":{"id":"tr-1"}}]`,
+			"sure-visa": `[{"id":"s-in","notes":"` + notes + `","transfer":{"id":"tr-1"}}]`,
+		}, LedgerEntry{SureTransactionID: "s-out", TransferID: "tr-1"}, true, []string{"/transactions/s-old"}},
+		{"transfer never created", map[string]string{
+			"sure-chk": `[{"id":"s-old","notes":"Imported via SimpleFIN. ID: T-old"}]`,
+		}, LedgerEntry{SureTransactionID: "s-old"}, false, nil},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		var deletes []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "DELETE" {
+				mu.Lock()
+				deletes = append(deletes, r.URL.Path)
+				mu.Unlock()
+				return
+			}
+			transactions := tt.transactions[r.URL.Query().Get("account_id")]
+			if transactions == "" {
+				transactions = "[]"
+			}
+			fmt.Fprintf(w, `{"transactions":%s}`, transactions)
+		}))
+
+		ledger := openTestLedger(t)
+		old := LedgerEntry{AccountID: "chk", TransactionID: "T-old", SureTransactionID: "s-old", Amount: "-100.00", Date: "2024-05-01"}
+		newSide := LedgerEntry{AccountID: "visa", TransactionID: "T-new", SureAccountID: "sure-visa", Amount: "100.00", Date: "2024-05-02"}
+		oldIntent, newIntent := old, newSide
+		oldIntent.SureAccountID = "sure-chk"
+		oldIntent.TransferID, newIntent.TransferID = unknownTransferID, unknownTransferID
+		if err := ledger.Commit(LedgerBatch{Put: []LedgerEntry{old}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ledger.RecordIntents([]LedgerEntry{oldIntent, newIntent}); err != nil {
+			t.Fatal(err)
+		}
+
+		RecoverIntents(Config{SureBaseURL: server.URL}, ledger)
+		server.Close()
+
+		entry, _, _ := ledger.Get("T-old")
+		if entry.SureTransactionID != tt.wantOld.SureTransactionID || entry.TransferID != tt.wantOld.TransferID {
+			t.Errorf("%s: replaced side recorded as %+v, want Sure ID %q and transfer %q", tt.name, entry,
+				tt.wantOld.SureTransactionID, tt.wantOld.TransferID)
+		}
+		entry, ok, _ := ledger.Get("T-new")
+		if ok != tt.wantNew || (ok && (entry.SureTransactionID != "s-in" || entry.TransferID != "tr-1")) {
+			t.Errorf("%s: new side recorded as %+v, %v", tt.name, entry, ok)
+		}
+		if !slices.Equal(deletes, tt.wantDeletes) {
+			t.Errorf("%s: deleted %v, want %v", tt.name, deletes, tt.wantDeletes)
+		}
+		if intents, _ := ledger.Intents(); len(intents) != 0 {
+			t.Errorf("%s: %d intents left", tt.name, len(intents))
+		}
+	}
+}